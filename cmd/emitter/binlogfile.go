@@ -0,0 +1,265 @@
+package main
+
+// Offline mode: decode binlog files from disk instead of a replication stream
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// binlogFileConfig selects binlog files to publish instead of streaming from
+// MySQL, e.g. files restored from a backup. Start and Stop positions with an
+// empty Name apply to the first and last file; a zero Pos means the start or
+// end of the file.
+type binlogFileConfig struct {
+	Files      []string
+	Start      mysql.Position
+	Stop       mysql.Position
+	StartTime  time.Time
+	StopTime   time.Time
+	SchemaFile string
+}
+
+// loadBinlogFileConfig reads BINLOG_FILES (comma-separated paths or globs),
+// BINLOG_START_POSITION and BINLOG_STOP_POSITION ("[file:]pos"),
+// BINLOG_START_TIME and BINLOG_STOP_TIME (RFC 3339) and SCHEMA_SNAPSHOT_FILE.
+// It returns nil when BINLOG_FILES is unset.
+func loadBinlogFileConfig() (*binlogFileConfig, error) {
+	list := strings.TrimSpace(os.Getenv("BINLOG_FILES"))
+	if list == "" {
+		return nil, nil
+	}
+	bf := &binlogFileConfig{SchemaFile: os.Getenv("SCHEMA_SNAPSHOT_FILE")}
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BINLOG_FILES pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("BINLOG_FILES: no file matches %q", pattern)
+		}
+		sort.Strings(matches)
+		bf.Files = append(bf.Files, matches...)
+	}
+
+	var err error
+	if bf.Start, err = parseBinlogPosition("BINLOG_START_POSITION"); err != nil {
+		return nil, err
+	}
+	if bf.Stop, err = parseBinlogPosition("BINLOG_STOP_POSITION"); err != nil {
+		return nil, err
+	}
+	for _, t := range []struct {
+		env string
+		dst *time.Time
+	}{
+		{"BINLOG_START_TIME", &bf.StartTime},
+		{"BINLOG_STOP_TIME", &bf.StopTime},
+	} {
+		if v := strings.TrimSpace(os.Getenv(t.env)); v != "" {
+			if *t.dst, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, fmt.Errorf("invalid %s: %q", t.env, v)
+			}
+		}
+	}
+	if _, err := bf.selected(); err != nil {
+		return nil, err
+	}
+	return bf, nil
+}
+
+func parseBinlogPosition(env string) (mysql.Position, error) {
+	v := strings.TrimSpace(os.Getenv(env))
+	if v == "" {
+		return mysql.Position{}, nil
+	}
+	var p mysql.Position
+	pos := v
+	if i := strings.LastIndex(v, ":"); i >= 0 {
+		p.Name, pos = v[:i], v[i+1:]
+	}
+	n, err := strconv.ParseUint(pos, 10, 32)
+	if err != nil {
+		return mysql.Position{}, fmt.Errorf("invalid %s: %q (want [file:]pos)", env, v)
+	}
+	p.Pos = uint32(n)
+	return p, nil
+}
+
+// selected returns the files from the start position's file to the stop
+// position's file. A stop position before the start position is an error.
+func (bf *binlogFileConfig) selected() ([]string, error) {
+	files := bf.Files
+	if len(files) == 0 {
+		return nil, fmt.Errorf("BINLOG_FILES lists no files")
+	}
+	find := func(env, name string) (int, error) {
+		for i, f := range files {
+			if filepath.Base(f) == filepath.Base(name) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%s: %s is not in BINLOG_FILES", env, name)
+	}
+	first, last := 0, len(files)-1
+	var err error
+	if bf.Start.Name != "" {
+		if first, err = find("BINLOG_START_POSITION", bf.Start.Name); err != nil {
+			return nil, err
+		}
+	}
+	if bf.Stop.Name != "" {
+		if last, err = find("BINLOG_STOP_POSITION", bf.Stop.Name); err != nil {
+			return nil, err
+		}
+	}
+	if last < first || last == first && bf.Stop.Pos > 0 && bf.Stop.Pos <= bf.Start.Pos {
+		return nil, fmt.Errorf("BINLOG_STOP_POSITION %s:%d is not after BINLOG_START_POSITION %s:%d",
+			filepath.Base(files[last]), bf.Stop.Pos, filepath.Base(files[first]), bf.Start.Pos)
+	}
+	return files[first : last+1], nil
+}
+
+// loadSchemaSnapshot reads column names from a JSON file mapping "db.table"
+// to {"columns": [...], "primary_key": [...]}, in table order.
+func loadSchemaSnapshot(path string) (map[tableKey]*schemaInfo, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read SCHEMA_SNAPSHOT_FILE: %w", err)
+	}
+	var raw map[string]struct {
+		Columns    []string `json:"columns"`
+		PrimaryKey []string `json:"primary_key"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse SCHEMA_SNAPSHOT_FILE: %w", err)
+	}
+	out := make(map[tableKey]*schemaInfo, len(raw))
+	for name, t := range raw {
+		db, table, ok := strings.Cut(name, ".")
+		if !ok || db == "" || table == "" || len(t.Columns) == 0 {
+			return nil, fmt.Errorf("SCHEMA_SNAPSHOT_FILE: %q needs a db.table name and columns", name)
+		}
+		ti := &schemaInfo{Columns: t.Columns, ColIndex: make(map[string]int, len(t.Columns)), PKCols: t.PrimaryKey}
+		for i, c := range t.Columns {
+			ti.ColIndex[c] = i
+		}
+		for _, c := range t.PrimaryKey {
+			if _, ok := ti.ColIndex[c]; !ok {
+				return nil, fmt.Errorf("SCHEMA_SNAPSHOT_FILE: %s primary key column %q is not a column", name, c)
+			}
+		}
+		out[tableKey{schema: db, table: table}] = ti
+	}
+	return out, nil
+}
+
+// snapshotSchema returns the columns of the table tm maps from the schema
+// snapshot, falling back to the column names the binlog carries when written
+// with binlog_row_metadata=FULL.
+func (h *rowHandler) snapshotSchema(tm *replication.TableMapEvent) (*schemaInfo, error) {
+	ti, ok := h.snapshot[tableKey{schema: string(tm.Schema), table: string(tm.Table)}]
+	if ok && len(ti.Columns) == int(tm.ColumnCount) {
+		return ti, nil
+	}
+	if names := tm.ColumnNameString(); len(names) == int(tm.ColumnCount) {
+		ti := &schemaInfo{Columns: names, ColIndex: make(map[string]int, len(names))}
+		for i, c := range names {
+			ti.ColIndex[c] = i
+		}
+		for _, i := range tm.PrimaryKey {
+			if int(i) < len(names) {
+				ti.PKCols = append(ti.PKCols, names[i])
+			}
+		}
+		return ti, nil
+	}
+	if ok {
+		return nil, fmt.Errorf("schema snapshot lists %d columns, the binlog %d", len(ti.Columns), tm.ColumnCount)
+	}
+	return nil, fmt.Errorf("not in the schema snapshot, and the binlog has no column names (binlog_row_metadata=MINIMAL)")
+}
+
+var errStopReached = errors.New("binlog stop reached")
+
+// readBinlogFiles publishes the row events of the configured binlog files
+// through the same decoding path as streamChanges, then returns.
+func readBinlogFiles(ctx context.Context, cfg *Config) error {
+	bf := cfg.BinlogFiles
+	files, err := bf.selected()
+	if err != nil {
+		return err
+	}
+	h := newRowHandler(nil, cfg.Query, "")
+	if h.snapshot, err = loadSchemaSnapshot(bf.SchemaFile); err != nil {
+		return err
+	}
+	h.heartbeat = cfg.Heartbeat
+
+	parser := newBinlogParser()
+
+	var last mysql.Position
+	for i, path := range files {
+		h.file = filepath.Base(path)
+		var offset int64
+		if i == 0 {
+			offset = int64(bf.Start.Pos)
+		}
+		lastFile := i == len(files)-1
+		slog.Info("Reading binlog file", "file", path, binlogPos(h.file, uint32(max(offset, 4))))
+
+		err := parser.ParseFile(path, offset, func(ev *replication.BinlogEvent) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			header := ev.Header
+			if lastFile && bf.Stop.Pos > 0 && header.LogPos > 0 && header.LogPos-header.EventSize >= bf.Stop.Pos {
+				return errStopReached
+			}
+			var at time.Time
+			if header.Timestamp > 0 {
+				at = time.Unix(int64(header.Timestamp), 0)
+			}
+			if !bf.StopTime.IsZero() && !at.IsZero() && !at.Before(bf.StopTime) {
+				return errStopReached
+			}
+			if !bf.StartTime.IsZero() && !at.IsZero() && at.Before(bf.StartTime) && isRowsEventType(header.EventType) {
+				return nil
+			}
+			health.eventSeen()
+			h.handleEvent(ctx, ev)
+			if header.LogPos > 0 {
+				last = mysql.Position{Name: h.file, Pos: header.LogPos}
+			}
+			return nil
+		})
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, errStopReached):
+			slog.Info("Binlog stop reached", binlogPos(last.Name, last.Pos))
+			return nil
+		case err != nil:
+			return fmt.Errorf("read %s after %s:%d: %w", path, last.Name, last.Pos, err)
+		}
+	}
+	slog.Info("Binlog files done", binlogPos(last.Name, last.Pos))
+	return nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+func TestParseBinlogPosition(t *testing.T) {
+	for _, tc := range []struct {
+		v    string
+		want mysql.Position
+		err  bool
+	}{
+		{v: "", want: mysql.Position{}},
+		{v: "1234", want: mysql.Position{Pos: 1234}},
+		{v: " binlog.000042:4 ", want: mysql.Position{Name: "binlog.000042", Pos: 4}},
+		{v: "/backups/c:d/binlog.000001:120", want: mysql.Position{Name: "/backups/c:d/binlog.000001", Pos: 120}},
+		{v: "binlog.000042", err: true},
+		{v: "binlog.000042:", err: true},
+		{v: "binlog.000042:-1", err: true},
+		{v: "99999999999", err: true},
+	} {
+		t.Setenv("BINLOG_START_POSITION", tc.v)
+		got, err := parseBinlogPosition("BINLOG_START_POSITION")
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: accepted as %v", tc.v, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%q = %v, %v; want %v", tc.v, got, err, tc.want)
+		}
+	}
+}
+
+// binlogDir creates empty binlog files named names and returns their dir.
+func binlogDir(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, n := range names {
+		if err := os.WriteFile(filepath.Join(dir, n), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBinlogFileSelection(t *testing.T) {
+	dir := binlogDir(t, "binlog.000001", "binlog.000002", "binlog.000003", "binlog.000004")
+	for _, tc := range []struct {
+		name        string
+		start, stop string
+		want        []string // base names
+		err         string
+	}{
+		{name: "all", want: []string{"binlog.000001", "binlog.000002", "binlog.000003", "binlog.000004"}},
+		{name: "from start file", start: "binlog.000003:120", want: []string{"binlog.000003", "binlog.000004"}},
+		{name: "to stop file", stop: "binlog.000002:4000", want: []string{"binlog.000001", "binlog.000002"}},
+		{name: "start and stop", start: "binlog.000002:4", stop: "binlog.000003:900", want: []string{"binlog.000002", "binlog.000003"}},
+		{name: "one file", start: "binlog.000002:4", stop: "binlog.000002:900", want: []string{"binlog.000002"}},
+		{name: "stop file before start file", start: "binlog.000003:4", stop: "binlog.000002:900", err: "is not after"},
+		{name: "stop before start in a file", start: "binlog.000002:900", stop: "binlog.000002:120", err: "is not after"},
+		{name: "unknown start file", start: "binlog.000009:4", err: "BINLOG_START_POSITION: binlog.000009 is not in BINLOG_FILES"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("BINLOG_FILES", filepath.Join(dir, "binlog.00000[12]")+","+filepath.Join(dir, "binlog.00000[34]"))
+			t.Setenv("BINLOG_START_POSITION", tc.start)
+			t.Setenv("BINLOG_STOP_POSITION", tc.stop)
+			bf, err := loadBinlogFileConfig()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := bf.selected()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range files {
+				got = append(got, filepath.Base(f))
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("selected %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadBinlogFileConfigErrors(t *testing.T) {
+	dir := binlogDir(t, "binlog.000001")
+	for _, tc := range []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"BINLOG_FILES": filepath.Join(dir, "missing.*")}, "no file matches"},
+		{map[string]string{"BINLOG_FILES": " , "}, "lists no files"},
+		{map[string]string{"BINLOG_STOP_TIME": "yesterday"}, "invalid BINLOG_STOP_TIME"},
+	} {
+		t.Setenv("BINLOG_FILES", filepath.Join(dir, "binlog.*"))
+		t.Setenv("BINLOG_STOP_TIME", "")
+		for k, v := range tc.env {
+			t.Setenv(k, v)
+		}
+		if _, err := loadBinlogFileConfig(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%v: error = %v, want %q", tc.env, err, tc.want)
+		}
+	}
+
+	t.Setenv("BINLOG_FILES", "")
+	if bf, err := loadBinlogFileConfig(); bf != nil || err != nil {
+		t.Errorf("without BINLOG_FILES = %v, %v; want nil", bf, err)
+	}
+}
+
+func writeSnapshot(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSchemaSnapshot(t *testing.T) {
+	snap, err := loadSchemaSnapshot(writeSnapshot(t, `{"crm.leads": {"columns": ["id", "name", "phone"], "primary_key": ["id"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ti := snap[tableKey{schema: "crm", table: "leads"}]
+	if ti == nil || ti.ColIndex["phone"] != 2 || !reflect.DeepEqual(ti.PKCols, []string{"id"}) {
+		t.Fatalf("crm.leads = %+v", ti)
+	}
+
+	for _, tc := range []struct{ file, want string }{
+		{`{"leads": {"columns": ["id"]}}`, "needs a db.table name"},
+		{`{"crm.leads": {"columns": []}}`, "needs a db.table name and columns"},
+		{`{"crm.leads": {"columns": ["id"], "primary_key": ["uuid"]}}`, `primary key column "uuid"`},
+		{`[]`, "parse SCHEMA_SNAPSHOT_FILE"},
+	} {
+		if _, err := loadSchemaSnapshot(writeSnapshot(t, tc.file)); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", tc.file, err, tc.want)
+		}
+	}
+	if snap, err := loadSchemaSnapshot(""); snap != nil || err != nil {
+		t.Errorf("no snapshot file = %v, %v", snap, err)
+	}
+}
+
+func TestSnapshotSchema(t *testing.T) {
+	snap, err := loadSchemaSnapshot(writeSnapshot(t, `{"crm.leads": {"columns": ["id", "name"], "primary_key": ["id"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &rowHandler{snapshot: snap}
+	tableMap := func(table string, cols int, names ...string) *replication.TableMapEvent {
+		tm := &replication.TableMapEvent{Schema: []byte("crm"), Table: []byte(table), ColumnCount: uint64(cols), PrimaryKey: []uint64{1}}
+		for _, n := range names {
+			tm.ColumnName = append(tm.ColumnName, []byte(n))
+		}
+		return tm
+	}
+
+	for _, tc := range []struct {
+		name    string
+		tm      *replication.TableMapEvent
+		columns []string
+		pk      []string
+		err     string
+	}{
+		{name: "from snapshot", tm: tableMap("leads", 2), columns: []string{"id", "name"}, pk: []string{"id"}},
+		{name: "full metadata wins over a stale snapshot", tm: tableMap("leads", 3, "id", "uuid", "name"), columns: []string{"id", "uuid", "name"}, pk: []string{"uuid"}},
+		{name: "full metadata without snapshot", tm: tableMap("notes", 2, "id", "body"), columns: []string{"id", "body"}, pk: []string{"body"}},
+		{name: "stale snapshot, minimal metadata", tm: tableMap("leads", 3), err: "lists 2 columns, the binlog 3"},
+		{name: "minimal metadata", tm: tableMap("notes", 2), err: "binlog_row_metadata=MINIMAL"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ti, err := h.snapshotSchema(tc.tm)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ti.Columns, tc.columns) || !reflect.DeepEqual(ti.PKCols, tc.pk) {
+				t.Errorf("columns %v, pk %v; want %v, %v", ti.Columns, ti.PKCols, tc.columns, tc.pk)
+			}
+		})
+	}
+}
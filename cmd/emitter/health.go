@@ -0,0 +1,170 @@
+package main
+
+// HTTP endpoints: metrics, liveness and readiness
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/sink"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultHealthMaxEventAge = 2 * time.Minute
+
+var health = newHealthState()
+
+// healthState tracks the replication session for /healthz and /readyz.
+type healthState struct {
+	started   time.Time
+	lastEvent atomic.Int64 // unix nanos of the last binlog event, heartbeats included
+
+	// heartbeats is set when HEARTBEAT_TABLE is; liveness then also needs
+	// our own heartbeat rows to come back through the binlog.
+	heartbeats    bool
+	lastHeartbeat atomic.Int64 // unix nanos
+
+	mu        sync.Mutex
+	connected bool
+	lastError string
+}
+
+func newHealthState() *healthState {
+	return &healthState{started: time.Now()}
+}
+
+func (h *healthState) setConnected(connected bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.connected = connected
+	if err != nil {
+		h.lastError = err.Error()
+	} else if connected {
+		h.lastError = ""
+	}
+}
+
+func (h *healthState) eventSeen() {
+	h.lastEvent.Store(time.Now().UnixNano())
+}
+
+func (h *healthState) heartbeatSeen(t time.Time) {
+	h.lastHeartbeat.Store(t.UnixNano())
+}
+
+// heartbeatAge is the time since a heartbeat row was last read back, or
+// since startup when none has been.
+func (h *healthState) heartbeatAge() time.Duration {
+	last := h.started
+	if n := h.lastHeartbeat.Load(); n > 0 {
+		last = time.Unix(0, n)
+	}
+	return time.Since(last)
+}
+
+// eventAge is the time since the last binlog event, or since startup when
+// none has arrived yet.
+func (h *healthState) eventAge() time.Duration {
+	last := h.started
+	if n := h.lastEvent.Load(); n > 0 {
+		last = time.Unix(0, n)
+	}
+	return time.Since(last)
+}
+
+type healthReport struct {
+	Status               string        `json:"status"`
+	ReplicationConnected bool          `json:"replication_connected"`
+	LastEventAgeSeconds  float64       `json:"last_event_age_seconds"`
+	HeartbeatAgeSeconds  *float64      `json:"last_heartbeat_age_seconds,omitempty"`
+	LastError            string        `json:"last_error,omitempty"`
+	Checkpoint           string        `json:"checkpoint,omitempty"`
+	Sink                 string        `json:"sink,omitempty"`
+	SinkError            string        `json:"sink_error,omitempty"`
+	Sinks                []sink.Health `json:"sinks,omitempty"`
+}
+
+// report checks health; with ready set it also requires a live replication
+// session and a reachable sink.
+func (h *healthState) report(ctx context.Context, maxAge time.Duration, ready bool) (healthReport, bool) {
+	h.mu.Lock()
+	r := healthReport{ReplicationConnected: h.connected, LastError: h.lastError}
+	h.mu.Unlock()
+
+	age := h.eventAge()
+	r.LastEventAgeSeconds = age.Seconds()
+	ok := age < maxAge
+	if h.heartbeats {
+		hbAge := h.heartbeatAge().Seconds()
+		r.HeartbeatAgeSeconds = &hbAge
+		ok = ok && hbAge < maxAge.Seconds()
+	}
+	if pipeline != nil {
+		if cp := pipeline.Checkpoint(); cp.Name != "" {
+			r.Checkpoint = cp.String()
+		}
+	}
+
+	if ready {
+		ok = ok && r.ReplicationConnected
+		if publisher != nil {
+			r.Sink = "ok"
+			pingCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			if err := publisher.Ping(pingCtx); err != nil {
+				r.Sink, r.SinkError = "error", err.Error()
+				ok = false
+			}
+			cancel()
+			r.Sinks = publisher.SinkHealth()
+		}
+	}
+
+	r.Status = "ok"
+	if !ok {
+		r.Status = "unavailable"
+	}
+	return r, ok
+}
+
+func healthHandler(maxAge time.Duration, ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r, ok := health.report(req.Context(), maxAge, ready)
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(r)
+	}
+}
+
+// serveHTTP exposes /metrics, /healthz (liveness: binlog events, heartbeats
+// included, keep arriving, as do heartbeat rows when HEARTBEAT_TABLE is set),
+// /readyz (also connected to MySQL and the sink) and /loglevel (read or
+// change the log level) on addr until ctx is done.
+func serveHTTP(ctx context.Context, addr string, maxEventAge time.Duration) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", healthHandler(maxEventAge, false))
+	mux.Handle("/readyz", healthHandler(maxEventAge, true))
+	mux.Handle("/loglevel", logging.LevelHandler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving /metrics, /healthz, /readyz and /loglevel", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("http server", logging.Err(err))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+// Heartbeat table: periodic writes observed back through the binlog
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/logging"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+const defaultHeartbeatInterval = 10 * time.Second
+
+// heartbeatConfig describes the heartbeat table. The emitter writes the
+// current time into row ID every Interval; when the row comes back through
+// the binlog the difference is the end-to-end replication lag, published as
+// a "heartbeat" event.
+type heartbeatConfig struct {
+	DB       string
+	Table    string
+	ID       uint32
+	Interval time.Duration
+}
+
+// loadHeartbeatConfig reads HEARTBEAT_TABLE ("table" in DB_NAME, or
+// "db.table"), HEARTBEAT_INTERVAL and HEARTBEAT_ID (default SERVER_ID). It
+// returns nil when HEARTBEAT_TABLE is unset.
+func loadHeartbeatConfig(defaultDB string, serverID uint32) (*heartbeatConfig, error) {
+	name := strings.TrimSpace(os.Getenv("HEARTBEAT_TABLE"))
+	if name == "" {
+		return nil, nil
+	}
+	hb := &heartbeatConfig{DB: defaultDB, Table: name, ID: serverID, Interval: defaultHeartbeatInterval}
+	if db, table, ok := strings.Cut(name, "."); ok {
+		hb.DB, hb.Table = db, table
+	}
+	if hb.DB == "" || hb.Table == "" {
+		return nil, fmt.Errorf("invalid HEARTBEAT_TABLE: %q", name)
+	}
+	if v := os.Getenv("HEARTBEAT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid HEARTBEAT_INTERVAL: %q", v)
+		}
+		hb.Interval = d
+	}
+	if v := os.Getenv("HEARTBEAT_ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HEARTBEAT_ID: %w", err)
+		}
+		hb.ID = uint32(id)
+	}
+	return hb, nil
+}
+
+func (hb *heartbeatConfig) String() string {
+	return hb.DB + "." + hb.Table
+}
+
+func (hb *heartbeatConfig) quotedName() string {
+	quote := func(s string) string { return "`" + strings.ReplaceAll(s, "`", "``") + "`" }
+	return quote(hb.DB) + "." + quote(hb.Table)
+}
+
+// runHeartbeats creates the heartbeat table if needed and writes a heartbeat
+// every interval until ctx is done. Write errors are logged once per outage;
+// the binlog stream is unaffected.
+func runHeartbeats(ctx context.Context, db *sql.DB, hb *heartbeatConfig) {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+hb.quotedName()+` (
+		id INT UNSIGNED NOT NULL PRIMARY KEY,
+		written_at_us BIGINT NOT NULL
+	)`)
+	if err != nil && ctx.Err() == nil {
+		// The table may have been created by someone with the privilege.
+		slog.Warn("create heartbeat table", logging.KeyTable, hb.String(), logging.Err(err))
+	}
+	upsert := `INSERT INTO ` + hb.quotedName() + ` (id, written_at_us) VALUES (?, ?)
+		ON DUPLICATE KEY UPDATE written_at_us = VALUES(written_at_us)`
+
+	ticker := time.NewTicker(hb.Interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		_, err := db.ExecContext(ctx, upsert, hb.ID, time.Now().UnixMicro())
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil && !failing:
+			slog.Warn("write heartbeat", logging.KeyTable, hb.String(), logging.Err(err))
+			failing = true
+		case err == nil && failing:
+			slog.Info("Heartbeat writes recovered", logging.KeyTable, hb.String())
+			failing = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// matches reports whether db.table is the heartbeat table.
+func (hb *heartbeatConfig) matches(db, table string) bool {
+	return hb != nil && db == hb.DB && table == hb.Table
+}
+
+// handleRows turns this emitter's heartbeat rows into heartbeat
+// events. Rows written by other emitters sharing the table are skipped.
+func (hb *heartbeatConfig) handleRows(header *replication.EventHeader, ti *schemaInfo, rows [][]interface{}) {
+	if ti == nil {
+		slog.Warn("no schema for heartbeat table", logging.KeyTable, hb.String())
+		return
+	}
+	idCol, ok1 := ti.ColIndex["id"]
+	tsCol, ok2 := ti.ColIndex["written_at_us"]
+	if !ok1 || !ok2 {
+		slog.Warn("heartbeat table needs id and written_at_us columns", logging.KeyTable, hb.String())
+		return
+	}
+
+	step := 1
+	switch header.EventType {
+	case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2, replication.PARTIAL_UPDATE_ROWS_EVENT:
+		step = 2 // before and after images; use the after image
+	case replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		return
+	}
+	for i := step - 1; i < len(rows); i += step {
+		row := rows[i]
+		if len(row) != len(ti.Columns) {
+			continue
+		}
+		id, ok := toInt64(row[idCol])
+		if !ok || id != int64(hb.ID) {
+			continue
+		}
+		us, ok := toInt64(row[tsCol])
+		if !ok {
+			continue
+		}
+		hb.emit(time.UnixMicro(us))
+	}
+}
+
+func (hb *heartbeatConfig) emit(written time.Time) {
+	now := time.Now()
+	lag := max(0, now.Sub(written))
+	health.heartbeatSeen(now)
+	metrics.heartbeatLag.Set(lag.Seconds())
+	metrics.lastHeartbeat.SetToCurrentTime()
+
+	e := &event.RowEvent{
+		Op:        event.OpHeartbeat,
+		Timestamp: toIST(now.UTC().Format(time.RFC3339)),
+		DB:        hb.DB,
+		Table:     hb.Table,
+		RowKey:    hb.ID,
+		Heartbeat: &event.Heartbeat{
+			ID:              hb.ID,
+			WrittenAt:       written.UTC().Format(time.RFC3339Nano),
+			ObservedAt:      now.UTC().Format(time.RFC3339Nano),
+			LagSeconds:      lag.Seconds(),
+			IntervalSeconds: hb.Interval.Seconds(),
+		},
+	}
+	slog.Debug("heartbeat observed", logging.KeyTable, hb.String(), "lag", lag)
+	emitRowEvent(e, event.OpHeartbeat, nil)
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,272 @@
+package main
+
+// Partial JSON updates (binlog_row_value_options=PARTIAL_JSON)
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/logging"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// jsonPathToken is one step of a MySQL JSON path: an object member or an
+// array index.
+type jsonPathToken struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// jsonDiffChange builds the column change for a JSON column that was logged
+// as a partial update. The diff is exposed as a JSON patch, and the full new
+// value is rebuilt from the before image when possible.
+func jsonDiffChange(col string, before interface{}, diff *replication.JsonDiff) event.ColumnChange {
+	cc := event.ColumnChange{
+		Column: col,
+		From:   sanitize(before),
+	}
+
+	tokens, err := parseMySQLJSONPath(diff.Path)
+	if err != nil {
+		slog.Warn("partial json update", "column", col, logging.Err(err))
+		cc.Patch = []event.JSONPatchOp{{Op: jsonPatchOpName(diff.Op), Path: diff.Path}}
+		cc.Partial = true
+		return cc
+	}
+
+	op := event.JSONPatchOp{Op: jsonPatchOpName(diff.Op), Path: jsonPointer(tokens)}
+	if diff.Op != replication.JsonDiffOperationRemove {
+		op.Value = json.RawMessage(diff.Value)
+	}
+	cc.Patch = []event.JSONPatchOp{op}
+
+	full, err := rebuildJSONValue(before, tokens, diff)
+	if err != nil {
+		cc.Partial = true
+		return cc
+	}
+	cc.To = full
+	return cc
+}
+
+func jsonPatchOpName(op replication.JsonDiffOperation) string {
+	switch op {
+	case replication.JsonDiffOperationReplace:
+		return "replace"
+	case replication.JsonDiffOperationInsert:
+		return "add"
+	case replication.JsonDiffOperationRemove:
+		return "remove"
+	default:
+		return strings.ToLower(op.String())
+	}
+}
+
+// rebuildJSONValue applies diff to the before image of a JSON column and
+// returns the resulting document as a JSON string, matching how full JSON
+// values are published.
+func rebuildJSONValue(before interface{}, tokens []jsonPathToken, diff *replication.JsonDiff) (string, error) {
+	var raw string
+	switch v := before.(type) {
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return "", fmt.Errorf("before image not available")
+	}
+
+	doc, err := decodeJSON(raw)
+	if err != nil {
+		return "", err
+	}
+
+	var val interface{}
+	if diff.Op != replication.JsonDiffOperationRemove {
+		if val, err = decodeJSON(diff.Value); err != nil {
+			return "", err
+		}
+	}
+
+	doc, err = applyJSONDiff(doc, tokens, diff.Op, val)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func decodeJSON(s string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// applyJSONDiff mirrors JSON_REPLACE, JSON_INSERT/JSON_ARRAY_INSERT and
+// JSON_REMOVE on a decoded document.
+func applyJSONDiff(doc interface{}, path []jsonPathToken, op replication.JsonDiffOperation, val interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		if op != replication.JsonDiffOperationReplace {
+			return nil, fmt.Errorf("%s on document root", op)
+		}
+		return val, nil
+	}
+
+	tok := path[0]
+	last := len(path) == 1
+
+	if tok.isIndex {
+		arr, ok := doc.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("path step [%d] is not an array", tok.index)
+		}
+		if !last {
+			if tok.index >= len(arr) {
+				return nil, fmt.Errorf("array index %d out of range", tok.index)
+			}
+			child, err := applyJSONDiff(arr[tok.index], path[1:], op, val)
+			if err != nil {
+				return nil, err
+			}
+			arr[tok.index] = child
+			return arr, nil
+		}
+		switch op {
+		case replication.JsonDiffOperationReplace:
+			if tok.index >= len(arr) {
+				return nil, fmt.Errorf("array index %d out of range", tok.index)
+			}
+			arr[tok.index] = val
+		case replication.JsonDiffOperationInsert:
+			if tok.index >= len(arr) {
+				return append(arr, val), nil
+			}
+			arr = append(arr[:tok.index+1], arr[tok.index:]...)
+			arr[tok.index] = val
+		case replication.JsonDiffOperationRemove:
+			if tok.index >= len(arr) {
+				return nil, fmt.Errorf("array index %d out of range", tok.index)
+			}
+			arr = append(arr[:tok.index], arr[tok.index+1:]...)
+		}
+		return arr, nil
+	}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("path step %q is not an object", tok.key)
+	}
+	if !last {
+		child, ok := obj[tok.key]
+		if !ok {
+			return nil, fmt.Errorf("member %q not found", tok.key)
+		}
+		child, err := applyJSONDiff(child, path[1:], op, val)
+		if err != nil {
+			return nil, err
+		}
+		obj[tok.key] = child
+		return obj, nil
+	}
+	switch op {
+	case replication.JsonDiffOperationReplace, replication.JsonDiffOperationInsert:
+		obj[tok.key] = val
+	case replication.JsonDiffOperationRemove:
+		delete(obj, tok.key)
+	}
+	return obj, nil
+}
+
+// parseMySQLJSONPath parses the normalized paths MySQL writes into partial
+// update events, e.g. $.a."b c"[2].
+func parseMySQLJSONPath(path string) ([]jsonPathToken, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("invalid json path %q", path)
+	}
+	var tokens []jsonPathToken
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if strings.HasPrefix(rest, `"`) {
+				end := closingQuote(rest)
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated member name in json path %q", path)
+				}
+				key, err := strconv.Unquote(rest[:end+1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid member name in json path %q: %w", path, err)
+				}
+				tokens = append(tokens, jsonPathToken{key: key})
+				rest = rest[end+1:]
+				continue
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty member name in json path %q", path)
+			}
+			tokens = append(tokens, jsonPathToken{key: rest[:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated array index in json path %q", path)
+			}
+			idx, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid array index in json path %q", path)
+			}
+			tokens = append(tokens, jsonPathToken{index: idx, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in json path %q", rest[0], path)
+		}
+	}
+	return tokens, nil
+}
+
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// jsonPointer renders a parsed path as an RFC 6901 JSON pointer.
+func jsonPointer(tokens []jsonPathToken) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteByte('/')
+		if t.isIndex {
+			b.WriteString(strconv.Itoa(t.index))
+			continue
+		}
+		b.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(t.key))
+	}
+	return b.String()
+}
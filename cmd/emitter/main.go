@@ -4,11 +4,12 @@ package main
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"reflect"
@@ -18,22 +19,26 @@ import (
 	"syscall"
 	"time"
 
+	"mysql_changelog_publisher/internal/envelope"
 	"mysql_changelog_publisher/internal/event"
-
-	"github.com/redis/go-redis/v9"
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/redisconn"
+	"mysql_changelog_publisher/internal/rotate"
+	"mysql_changelog_publisher/internal/sink"
+	"mysql_changelog_publisher/internal/tracing"
 
 	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/replication"
-	_ "github.com/go-sql-driver/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
 )
 
 const (
-	defaultRedisAddr      = "127.0.0.1:6379"
 	defaultRedisChannel   = "binlog:all"
 	defaultDBPort         = "3306"
 	defaultServerID       = uint32(100)
 	defaultReconnectDelay = 5 * time.Second
+	shutdownFlushTimeout  = 10 * time.Second
 )
 
 var publisher *Publisher
@@ -72,42 +77,55 @@ type Config struct {
 	DBPort         string
 	DBName         string
 	ServerID       uint32
-	RedisAddr      string
-	RedisPass      string
-	RedisDB        int
 	RedisChannel   string
 	ReconnectDelay time.Duration
 	LogFile        string
-}
+	LogRotation    rotate.Options // for LogFile
+	Query          queryOptions
+	Policies       *columnPolicies
 
-// Redis Publisher structure
-type Publisher struct {
-	r       *redis.Client
-	ctx     context.Context
-	channel string
-	logger  *EventLogger
-}
+	Redis             redisconn.Options
+	RedisTargets      []redisTarget
+	RedisTargetPolicy sink.Policy
 
-func NewPublisher(addr, pass string, db int, channel string, logger *EventLogger) *Publisher {
-	return &Publisher{
-		r: redis.NewClient(&redis.Options{
-			Addr:     addr,
-			Password: pass,
-			DB:       db,
-		}),
-		ctx:     context.Background(),
-		channel: channel,
-		logger:  logger,
-	}
+	DBTLS *tls.Config // nil unless DB_TLS or a DB_TLS_* file is set
+
+	SpoolDir          string
+	SpoolMaxBytes     int64
+	SpoolSegmentBytes int64
+
+	PublishWorkers int
+	PublishBatch   int
+	PublishQueue   int
+	CheckpointFile string
+
+	Sinks            []string
+	SinkFilePath     string
+	SinkFileMaxBytes int64
+	SinkFileMaxFiles int
+
+	NATSURL       string
+	NATSCredsFile string
+	NATSPrefix    string
+	NATSStream    string
+
+	MQTT sink.MQTTOptions
+
+	MetricsAddr       string
+	HealthMaxEventAge time.Duration
+
+	Heartbeat *heartbeatConfig // nil unless HEARTBEAT_TABLE is set
+
+	BinlogFiles *binlogFileConfig // nil unless BINLOG_FILES is set
 }
 
 type EventLogger struct {
 	mu   sync.Mutex
-	file *os.File
+	file *rotate.Writer
 }
 
-func NewEventLogger(path string) (*EventLogger, error) {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+func NewEventLogger(path string, o rotate.Options) (*EventLogger, error) {
+	f, err := rotate.OpenWith(path, o)
 	if err != nil {
 		return nil, err
 	}
@@ -133,26 +151,20 @@ func (l *EventLogger) Close() error {
 	return l.file.Close()
 }
 
-// PublishJSON publishes JSON bytes to Redis pub/sub channel
-func (p *Publisher) PublishJSON(jsonBytes []byte, eventID string) error {
-	if err := p.r.Publish(p.ctx, p.channel, string(jsonBytes)).Err(); err != nil {
-		return err
-	}
-	if p.logger != nil {
-		p.logger.Log(eventID, jsonBytes)
-	}
-	return nil
-}
-
 func main() {
 	if err := run(); err != nil {
-		log.Fatal(err)
+		slog.Error("emitter stopped", logging.Err(err))
+		os.Exit(1)
 	}
 }
 
 func run() error {
-	if err := godotenv.Load(); err != nil {
-		log.Printf("No .env file found: %v", err)
+	envErr := godotenv.Load()
+	if err := logging.Setup(os.Getenv); err != nil {
+		return err
+	}
+	if envErr != nil {
+		slog.Info("No .env file found", logging.Err(envErr))
 	}
 
 	cfg, err := loadConfig()
@@ -162,18 +174,111 @@ func run() error {
 
 	var msgLogger *EventLogger
 	if cfg.LogFile != "" {
-		msgLogger, err = NewEventLogger(cfg.LogFile)
+		msgLogger, err = NewEventLogger(cfg.LogFile, cfg.LogRotation)
 		if err != nil {
 			return fmt.Errorf("init message logger: %w", err)
 		}
 		defer msgLogger.Close()
 	}
 
-	publisher = NewPublisher(cfg.RedisAddr, cfg.RedisPass, cfg.RedisDB, cfg.RedisChannel, msgLogger)
+	policies = cfg.Policies
+
+	wrapper, err := envelope.WrapperFromEnv(os.Getenv)
+	if err != nil {
+		return err
+	}
+
+	out, err := buildSink(cfg)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	slog.Info("Publishing", "sink", out.Name())
+
+	// Outlives ctx so queued events can still be flushed at shutdown.
+	publishCtx, stopPublishing := context.WithCancel(context.Background())
+	defer stopPublishing()
+	publisher = NewPublisher(publishCtx, out, msgLogger, wrapper)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, "binlog-emitter")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Warn("flush traces", logging.Err(err))
+		}
+	}()
+	if tracing.Enabled() {
+		slog.Info("Exporting emit spans over OTLP")
+	}
+
+	var spools map[string]*Spool
+	closeSpools := func() {
+		for _, s := range spools {
+			s.Close()
+		}
+	}
+	if cfg.SpoolDir != "" {
+		spools, err = OpenSpools(cfg.SpoolDir, sink.Leaves(out), cfg.SpoolMaxBytes, cfg.SpoolSegmentBytes)
+		if err != nil {
+			return fmt.Errorf("open spool: %w", err)
+		}
+		defer closeSpools()
+		for t, s := range spools {
+			if s.Pending() {
+				slog.Info("Spool has undelivered events from a previous run; draining", "spool", s.dir, "sink", t)
+			}
+		}
+		publisher.UseSpools(spools)
+		go publisher.DrainSpools(ctx)
+	}
+
+	pipeline = NewPipeline(ctx, publisher, cfg.PublishWorkers, cfg.PublishBatch, cfg.PublishQueue, checkpointSaver(cfg.CheckpointFile))
+	defer func() {
+		// Flush queued events. With the sink down and the spool full this
+		// can't finish, so give up after a grace period.
+		flushed := make(chan struct{})
+		go func() {
+			pipeline.Close()
+			close(flushed)
+		}()
+		select {
+		case <-flushed:
+		case <-time.After(shutdownFlushTimeout):
+			// Abort in-flight sink calls and stop waiting: the checkpoint
+			// only covers events that were delivered or spooled.
+			slog.Warn("publish queue not flushed; dropping remaining events", "timeout", shutdownFlushTimeout)
+			stopPublishing()
+			closeSpools()
+		}
+		if cfg.CheckpointFile != "" {
+			if cp := pipeline.Checkpoint(); cp.Name != "" {
+				if err := saveCheckpoint(cfg.CheckpointFile, cp); err != nil {
+					slog.Error("save checkpoint", logging.Err(err))
+				}
+			}
+		}
+	}()
+
+	if cfg.Heartbeat != nil && cfg.BinlogFiles == nil {
+		health.heartbeats = true
+		slog.Info("Writing heartbeats", "table", cfg.Heartbeat.String(), "interval", cfg.Heartbeat.Interval)
+	}
+	if cfg.MetricsAddr != "" {
+		go serveHTTP(ctx, cfg.MetricsAddr, cfg.HealthMaxEventAge)
+	}
+	go runNotifier(ctx, cfg.HealthMaxEventAge)
+	go logging.ToggleDebugOnSignal(ctx)
+	if msgLogger != nil {
+		go rotate.ReopenOnSignal(ctx, msgLogger.file)
+	}
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(sigCh)
@@ -181,18 +286,28 @@ func run() error {
 	go func() {
 		select {
 		case sig := <-sigCh:
-			log.Printf("signal %s received, shutting down...", sig)
+			slog.Info("signal received, shutting down", "signal", sig.String())
 			cancel()
 		case <-ctx.Done():
 		}
 	}()
 
+	if cfg.BinlogFiles != nil {
+		err := readBinlogFiles(ctx, cfg)
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
 	for {
 		if err := streamChanges(ctx, cfg); err != nil {
 			if errors.Is(err, context.Canceled) {
 				return nil
 			}
-			log.Printf("replication error: %v; retrying in %s", err, cfg.ReconnectDelay)
+			slog.Error("replication error; retrying", logging.Err(err), "retry_in", cfg.ReconnectDelay)
+			health.setConnected(false, err)
+			metrics.reconnects.Inc()
 			select {
 			case <-time.After(cfg.ReconnectDelay):
 			case <-ctx.Done():
@@ -211,25 +326,55 @@ func loadConfig() (*Config, error) {
 		DBHost:       os.Getenv("DB_HOST"),
 		DBPort:       os.Getenv("DB_PORT"),
 		DBName:       os.Getenv("DB_NAME"),
-		RedisAddr:    os.Getenv("REDIS_ADDR"),
-		RedisPass:    os.Getenv("REDIS_PASS"),
 		RedisChannel: os.Getenv("REDIS_CHANNEL"),
 		LogFile:      os.Getenv("MESSAGE_LOG_FILE"),
-	}
+		SpoolDir:     os.Getenv("SPOOL_DIR"),
+		SinkFilePath: os.Getenv("SINK_FILE_PATH"),
+
+		NATSURL:       os.Getenv("NATS_URL"),
+		NATSCredsFile: os.Getenv("NATS_CREDS"),
+		NATSPrefix:    os.Getenv("NATS_SUBJECT_PREFIX"),
+		NATSStream:    os.Getenv("NATS_STREAM"),
 
-	if cfg.DBUser == "" {
-		return nil, fmt.Errorf("DB_USER is required")
+		CheckpointFile: os.Getenv("CHECKPOINT_FILE"),
+		MetricsAddr:    os.Getenv("METRICS_ADDR"),
 	}
-	if cfg.DBHost == "" {
-		return nil, fmt.Errorf("DB_HOST is required")
+
+	binlogFiles, err := loadBinlogFileConfig()
+	if err != nil {
+		return nil, err
 	}
-	if cfg.DBName == "" {
-		return nil, fmt.Errorf("DB_NAME is required")
+	cfg.BinlogFiles = binlogFiles
+	if binlogFiles != nil {
+		// Reading old binlogs must not move the live emitter's checkpoint.
+		cfg.CheckpointFile = ""
+	} else {
+		if cfg.DBUser == "" {
+			return nil, fmt.Errorf("DB_USER is required")
+		}
+		if cfg.DBHost == "" {
+			return nil, fmt.Errorf("DB_HOST is required")
+		}
+		if cfg.DBName == "" {
+			return nil, fmt.Errorf("DB_NAME is required")
+		}
 	}
 
 	if cfg.DBPort == "" {
 		cfg.DBPort = defaultDBPort
 	}
+	if path := os.Getenv("DB_PASS_FILE"); path != "" {
+		pass, err := readSecretFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read DB_PASS_FILE: %w", err)
+		}
+		cfg.DBPass = pass
+	}
+	dbTLS, err := loadMySQLTLS(cfg.DBHost)
+	if err != nil {
+		return nil, err
+	}
+	cfg.DBTLS = dbTLS
 
 	addr := os.Getenv("ADDR")
 	if addr == "" {
@@ -248,18 +393,16 @@ func loadConfig() (*Config, error) {
 		cfg.ServerID = uint32(id)
 	}
 
-	redisDBStr := os.Getenv("REDIS_DB")
-	if redisDBStr != "" {
-		val, err := strconv.Atoi(redisDBStr)
-		if err != nil {
-			return nil, fmt.Errorf("invalid REDIS_DB: %w", err)
-		}
-		cfg.RedisDB = val
+	cfg.Heartbeat, err = loadHeartbeatConfig(cfg.DBName, cfg.ServerID)
+	if err != nil {
+		return nil, err
 	}
 
-	if cfg.RedisAddr == "" {
-		cfg.RedisAddr = defaultRedisAddr
+	redisOpts, err := redisconn.FromEnv(os.Getenv)
+	if err != nil {
+		return nil, err
 	}
+	cfg.Redis = redisOpts
 	if cfg.RedisChannel == "" {
 		cfg.RedisChannel = os.Getenv("REDIS_STREAM")
 	}
@@ -267,6 +410,25 @@ func loadConfig() (*Config, error) {
 		cfg.RedisChannel = defaultRedisChannel
 	}
 
+	targets, err := parseRedisTargets(os.Getenv("REDIS_TARGETS"), cfg.RedisChannel, cfg.Redis.TLS)
+	if err != nil {
+		return nil, err
+	}
+	cfg.RedisTargets = targets
+	cfg.RedisTargetPolicy, err = sink.ParsePolicy(os.Getenv("REDIS_TARGET_POLICY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_TARGET_POLICY: %w", err)
+	}
+
+	cfg.HealthMaxEventAge = defaultHealthMaxEventAge
+	if v := os.Getenv("HEALTH_MAX_EVENT_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid HEALTH_MAX_EVENT_AGE: %q", v)
+		}
+		cfg.HealthMaxEventAge = d
+	}
+
 	delayStr := os.Getenv("RECONNECT_DELAY")
 	if delayStr == "" {
 		cfg.ReconnectDelay = defaultReconnectDelay
@@ -278,11 +440,148 @@ func loadConfig() (*Config, error) {
 		cfg.ReconnectDelay = delay
 	}
 
+	cfg.SpoolMaxBytes = defaultSpoolMaxBytes
+	if v := os.Getenv("SPOOL_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid SPOOL_MAX_BYTES: %q", v)
+		}
+		cfg.SpoolMaxBytes = n
+	}
+	cfg.SpoolSegmentBytes = defaultSpoolSegmentBytes
+	if v := os.Getenv("SPOOL_SEGMENT_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid SPOOL_SEGMENT_BYTES: %q", v)
+		}
+		cfg.SpoolSegmentBytes = n
+	}
+
+	sinks := os.Getenv("SINKS")
+	if sinks == "" {
+		sinks = defaultSinks
+	}
+	for _, name := range strings.Split(sinks, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			cfg.Sinks = append(cfg.Sinks, name)
+		}
+	}
+	if cfg.NATSURL == "" {
+		cfg.NATSURL = defaultNATSURL
+	}
+	if cfg.NATSPrefix == "" {
+		cfg.NATSPrefix = defaultNATSPrefix
+	}
+	cfg.MQTT = sink.MQTTOptions{
+		Broker:      os.Getenv("MQTT_BROKER"),
+		ClientID:    os.Getenv("MQTT_CLIENT_ID"),
+		Username:    os.Getenv("MQTT_USERNAME"),
+		Password:    os.Getenv("MQTT_PASSWORD"),
+		QoS:         defaultMQTTQoS,
+		Topic:       os.Getenv("MQTT_TOPIC"),
+		RetainTopic: os.Getenv("MQTT_RETAIN_TOPIC"),
+	}
+	if cfg.MQTT.Broker == "" {
+		cfg.MQTT.Broker = defaultMQTTBroker
+	}
+	if cfg.MQTT.ClientID == "" {
+		cfg.MQTT.ClientID = defaultMQTTClientID
+	}
+	if cfg.MQTT.Topic == "" {
+		cfg.MQTT.Topic = defaultMQTTTopic
+	}
+	if v := os.Getenv("MQTT_QOS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 2 {
+			return nil, fmt.Errorf("invalid MQTT_QOS: %q", v)
+		}
+		cfg.MQTT.QoS = byte(n)
+	}
+	for _, t := range strings.Split(os.Getenv("MQTT_TABLES"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			cfg.MQTT.Tables = append(cfg.MQTT.Tables, t)
+		}
+	}
+
+	if cfg.SinkFilePath == "" {
+		cfg.SinkFilePath = defaultSinkFilePath
+	}
+	cfg.SinkFileMaxBytes = defaultSinkFileMaxBytes
+	if v := os.Getenv("SINK_FILE_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid SINK_FILE_MAX_BYTES: %q", v)
+		}
+		cfg.SinkFileMaxBytes = n
+	}
+	cfg.SinkFileMaxFiles = defaultSinkFileMaxFiles
+	if v := os.Getenv("SINK_FILE_MAX_FILES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid SINK_FILE_MAX_FILES: %q", v)
+		}
+		cfg.SinkFileMaxFiles = n
+	}
+
+	cfg.LogRotation, err = rotate.OptionsFromEnv("MESSAGE_LOG_", os.Getenv, rotate.Options{
+		MaxBytes: defaultMessageLogMaxBytes,
+		MaxFiles: defaultMessageLogMaxFiles,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range []struct {
+		env string
+		dst *int
+		def int
+	}{
+		{"PUBLISH_WORKERS", &cfg.PublishWorkers, defaultPublishWorkers},
+		{"PUBLISH_BATCH", &cfg.PublishBatch, defaultPublishBatch},
+		{"PUBLISH_QUEUE", &cfg.PublishQueue, defaultPublishQueue},
+	} {
+		*opt.dst = opt.def
+		if v := os.Getenv(opt.env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s: %q", opt.env, v)
+			}
+			*opt.dst = n
+		}
+	}
+
+	query, err := loadQueryOptions()
+	if err != nil {
+		return nil, err
+	}
+	cfg.Query = query
+
+	cfg.Policies, err = loadColumnPolicies()
+	if err != nil {
+		return nil, err
+	}
+	// The statement quotes the values the policies protect, possibly for
+	// another table than the one an event is for (multi-table UPDATE).
+	if cfg.Policies != nil && cfg.Query.Include {
+		cfg.Query = cfg.Query.withLiteralRedaction()
+	}
+
 	return cfg, nil
 }
 
+// DSN returns the database/sql DSN, formatted by the driver so that
+// passwords containing '@', '/' or '?' parse back unchanged.
 func (c Config) DSN() string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", c.DBUser, c.DBPass, c.DBHost, c.DBPort, c.DBName)
+	dc := mysqldriver.NewConfig()
+	dc.User = c.DBUser
+	dc.Passwd = c.DBPass
+	dc.Net = "tcp"
+	dc.Addr = net.JoinHostPort(c.DBHost, c.DBPort)
+	dc.DBName = c.DBName
+	if c.DBTLS != nil {
+		dc.TLSConfig = mysqlTLSConfigName
+	}
+	return dc.FormatDSN()
 }
 
 func streamChanges(ctx context.Context, cfg *Config) error {
@@ -296,9 +595,9 @@ func streamChanges(ctx context.Context, cfg *Config) error {
 		return fmt.Errorf("ping mysql: %w", err)
 	}
 
-	file, pos, err := readMasterFilePos(ctx, sqlDB)
+	startPos, resumed, err := startPosition(ctx, sqlDB, cfg)
 	if err != nil {
-		return fmt.Errorf("read master status: %w", err)
+		return err
 	}
 
 	host, port := splitHostPort(cfg.Addr)
@@ -313,23 +612,30 @@ func streamChanges(ctx context.Context, cfg *Config) error {
 		ParseTime:       true,
 		HeartbeatPeriod: 30 * time.Second,
 		ReadTimeout:     90 * time.Second,
+		TLSConfig:       cfg.DBTLS,
 	}
 
 	syncer := replication.NewBinlogSyncer(syncerCfg)
 	defer syncer.Close()
 
-	startPos := mysql.Position{Name: file, Pos: uint32(pos)}
 	streamer, err := syncer.StartSync(startPos)
 	if err != nil {
 		return fmt.Errorf("start binlog sync: %w", err)
 	}
-	log.Printf("Streaming from master tip: %s:%d (realtime)", startPos.Name, startPos.Pos)
+	health.setConnected(true, nil)
+	if resumed {
+		slog.Info("Resuming from checkpoint", binlogPos(startPos.Name, startPos.Pos))
+	} else {
+		slog.Info("Streaming from master tip (realtime)", binlogPos(startPos.Name, startPos.Pos))
+	}
 
-	var (
-		tableMap sync.Map // map[uint64]*replication.TableMapEvent
-		schemaMu sync.Mutex
-		schema   = make(map[tableKey]*schemaInfo)
-	)
+	h := newRowHandler(sqlDB, cfg.Query, startPos.Name)
+	h.heartbeat = cfg.Heartbeat
+	if cfg.Heartbeat != nil {
+		hbCtx, stopHeartbeats := context.WithCancel(ctx)
+		defer stopHeartbeats()
+		go runHeartbeats(hbCtx, sqlDB, cfg.Heartbeat)
+	}
 
 	for {
 		ev, err := streamer.GetEvent(ctx)
@@ -340,65 +646,263 @@ func streamChanges(ctx context.Context, cfg *Config) error {
 			return fmt.Errorf("get event: %w", err)
 		}
 
-		switch e := ev.Event.(type) {
-		case *replication.RotateEvent:
-			log.Printf("Rotate to %s:%d", string(e.NextLogName), e.Position)
+		health.eventSeen()
+		h.handleEvent(ctx, ev)
+	}
+}
+
+// startPosition picks where replication starts: the saved checkpoint when
+// CHECKPOINT_FILE is set (or the last checkpoint reached by this process),
+// otherwise the current master tip.
+func startPosition(ctx context.Context, db *sql.DB, cfg *Config) (mysql.Position, bool, error) {
+	if cfg.CheckpointFile != "" {
+		if cp := pipeline.Checkpoint(); cp.Name != "" {
+			return cp, true, nil
+		}
+		cp, ok, err := loadCheckpoint(cfg.CheckpointFile)
+		if err != nil {
+			return mysql.Position{}, false, err
+		}
+		if ok {
+			return cp, true, nil
+		}
+	}
+
+	file, pos, err := readMasterFilePos(ctx, db)
+	if err != nil {
+		return mysql.Position{}, false, fmt.Errorf("read master status: %w", err)
+	}
+	return mysql.Position{Name: file, Pos: uint32(pos)}, false, nil
+}
 
-		case *replication.TableMapEvent:
-			tableMap.Store(e.TableID, e)
+// rowHandler turns decoded binlog events into published row events. It owns
+// the table-map and schema caches for a single replication session.
+type rowHandler struct {
+	db       *sql.DB
+	tableMap sync.Map // map[uint64]*replication.TableMapEvent
+	schemaMu sync.Mutex
+	schema   map[tableKey]*schemaInfo
+
+	queryOpts queryOptions
+	stmt      stmtContext // statement the following rows events belong to
+	file      string      // current binlog file, for checkpoints
+	inPayload bool        // decoding events embedded in a TransactionPayloadEvent
+	heartbeat *heartbeatConfig
+
+	// Parses TransactionPayloadEvent contents; set from the binlog's
+	// format description event.
+	payloadParser *replication.BinlogParser
+
+	// Reading binlog files (db is nil): column names come from here or the
+	// binlog itself.
+	snapshot map[tableKey]*schemaInfo
+
+	// Commit time of the current transaction, from its GTID event
+	// (MySQL 8.0.1+); zero when unknown.
+	commitTime time.Time
+}
 
-			key := tableKey{schema: string(e.Schema), table: string(e.Table)}
-			schemaMu.Lock()
-			if _, ok := schema[key]; !ok {
-				info, err := loadSchemaInfo(ctx, sqlDB, key.schema, key.table)
-				if err != nil {
-					log.Printf("warn: load schema for %s.%s: %v", key.schema, key.table, err)
-				} else {
-					schema[key] = info
-				}
-			}
-			schemaMu.Unlock()
+func newRowHandler(db *sql.DB, queryOpts queryOptions, file string) *rowHandler {
+	metrics.schemaTables.Set(0)
+	return &rowHandler{
+		db:        db,
+		schema:    make(map[tableKey]*schemaInfo),
+		queryOpts: queryOpts,
+		file:      file,
+	}
+}
 
-		case *replication.RowsEvent:
-			v, ok := tableMap.Load(e.TableID)
-			if !ok {
-				log.Printf("warn: missing table map for table_id=%d", e.TableID)
-				continue
+func (h *rowHandler) handleEvent(ctx context.Context, ev *replication.BinlogEvent) {
+	if !h.inPayload {
+		metrics.observeEvent(h.file, ev.Header.LogPos, ev.Header.Timestamp)
+	}
+
+	switch e := ev.Event.(type) {
+	case *replication.RotateEvent:
+		slog.Info("Rotate", binlogPos(string(e.NextLogName), uint32(e.Position)))
+		h.file = string(e.NextLogName)
+
+	case *replication.FormatDescriptionEvent:
+		p, err := newPayloadParser(e)
+		if err != nil {
+			slog.Error("format description event", binlogPos(h.file, ev.Header.LogPos), logging.Err(err))
+		}
+		h.payloadParser = p
+
+	case *replication.TableMapEvent:
+		h.tableMap.Store(e.TableID, e)
+
+		key := tableKey{schema: string(e.Schema), table: string(e.Table)}
+		h.schemaMu.Lock()
+		if _, ok := h.schema[key]; ok {
+			metrics.schemaLookups.WithLabelValues("hit").Inc()
+		} else {
+			info, err := h.loadSchema(ctx, e)
+			if err != nil {
+				metrics.schemaLookups.WithLabelValues("error").Inc()
+				slog.Warn("load schema", logging.KeyDB, key.schema, logging.KeyTable, key.table, logging.Err(err))
+			} else {
+				metrics.schemaLookups.WithLabelValues("miss").Inc()
+				h.schema[key] = info
+				metrics.schemaTables.Set(float64(len(h.schema)))
 			}
-			tm := v.(*replication.TableMapEvent)
-			dbName := string(tm.Schema)
-			tblName := string(tm.Table)
-			key := tableKey{schema: dbName, table: tblName}
-
-			schemaMu.Lock()
-			ti := schema[key]
-			schemaMu.Unlock()
-
-			switch ev.Header.EventType {
-			case replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
-				for _, row := range e.Rows {
-					printInsert(dbName, tblName, ti, row)
-				}
-			case replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
-				for _, row := range e.Rows {
-					printDelete(dbName, tblName, ti, row)
-				}
-			case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
-				for i := 0; i < len(e.Rows); i += 2 {
-					before := e.Rows[i]
-					after := e.Rows[i+1]
-					printUpdate(dbName, tblName, ti, before, after)
-				}
-			default:
-				// ignore other row event types
+		}
+		h.schemaMu.Unlock()
+
+	case *replication.RowsQueryEvent:
+		// binlog_rows_query_log_events=ON: the original statement precedes
+		// the rows events it produced.
+		q := string(e.Query)
+		h.stmt = stmtContext{
+			query:  h.queryOpts.format(q),
+			origin: parseOrigin(q),
+		}
+
+	case *replication.QueryEvent, *replication.XIDEvent, *replication.GTIDEvent:
+		// Transaction boundaries; a Rows_query never outlives its transaction.
+		h.stmt = stmtContext{}
+		switch e := e.(type) {
+		case *replication.GTIDEvent:
+			h.commitTime = time.Time{}
+			if e.OriginalCommitTimestamp > 0 {
+				h.commitTime = e.OriginalCommitTime()
 			}
+		case *replication.XIDEvent:
+			h.commitTime = time.Time{}
+		}
+		if isCommitPoint(e) {
+			h.markCheckpoint(ev.Header)
+		}
+
+	case *replication.RowsEvent:
+		h.handleRows(ev.Header, e)
 
-		default:
-			// ignore other event types
+	case *replication.TransactionPayloadEvent:
+		// binlog_transaction_compression=ON wraps the whole transaction
+		// (table maps, rows events, XID) in one compressed event. The
+		// library's own decoding of the inner events ignores UseDecimal and
+		// ParseTime, so decode them again as the stream does.
+		events, err := payloadEvents(h.payloadParser, e)
+		if err != nil {
+			slog.Error("decode transaction payload; its rows were NOT published",
+				binlogPos(h.file, ev.Header.LogPos), logging.Err(err))
 		}
+		h.inPayload = true
+		for _, inner := range events {
+			h.handleEvent(ctx, inner)
+		}
+		h.inPayload = false
+		// Embedded events carry no usable log position; the payload does.
+		h.markCheckpoint(ev.Header)
+
+	default:
+		if isRowsEventType(ev.Header.EventType) {
+			slog.Error("unsupported row event; its rows were NOT published",
+				"event_type", ev.Header.EventType.String(), "go_type", fmt.Sprintf("%T", ev.Event), binlogPos(h.file, ev.Header.LogPos))
+		}
+	}
+}
+
+// loadSchema returns the columns of the table tm maps, from MySQL or, when
+// reading binlog files, from the schema snapshot.
+func (h *rowHandler) loadSchema(ctx context.Context, tm *replication.TableMapEvent) (*schemaInfo, error) {
+	if h.db == nil {
+		return h.snapshotSchema(tm)
+	}
+	return loadSchemaInfo(ctx, h.db, string(tm.Schema), string(tm.Table))
+}
+
+// markCheckpoint offers the end of the current transaction as a resume point.
+func (h *rowHandler) markCheckpoint(header *replication.EventHeader) {
+	if h.inPayload || header.LogPos == 0 || h.file == "" {
+		return
+	}
+	pipeline.Mark(mysql.Position{Name: h.file, Pos: header.LogPos})
+}
+
+// isCommitPoint reports whether e ends a transaction: an XID commit, or a
+// statement other than BEGIN (DDL, or COMMIT for non-transactional tables).
+func isCommitPoint(e replication.Event) bool {
+	switch e := e.(type) {
+	case *replication.XIDEvent:
+		return true
+	case *replication.QueryEvent:
+		return !strings.EqualFold(strings.TrimSpace(string(e.Query)), "BEGIN")
+	}
+	return false
+}
+
+func (h *rowHandler) handleRows(header *replication.EventHeader, e *replication.RowsEvent) {
+	v, ok := h.tableMap.Load(e.TableID)
+	if !ok {
+		slog.Warn("missing table map", "table_id", e.TableID, binlogPos(h.file, header.LogPos))
+		return
+	}
+	tm := v.(*replication.TableMapEvent)
+	dbName := string(tm.Schema)
+	tblName := string(tm.Table)
+	key := tableKey{schema: dbName, table: tblName}
+
+	h.schemaMu.Lock()
+	ti := h.schema[key]
+	h.schemaMu.Unlock()
+
+	// Without a GTID commit timestamp, the statement's start time (whole
+	// seconds) is the closest thing to a commit time.
+	h.stmt.commit = h.commitTime
+	if h.stmt.commit.IsZero() && header.Timestamp > 0 {
+		h.stmt.commit = time.Unix(int64(header.Timestamp), 0)
+	}
+
+	slog.Debug("rows event", logging.KeyDB, dbName, logging.KeyTable, tblName,
+		"event_type", header.EventType.String(), "rows", len(e.Rows), binlogPos(h.file, header.LogPos))
+
+	if h.heartbeat.matches(dbName, tblName) {
+		// Heartbeats read back from binlog files are history, not lag.
+		if h.db != nil {
+			h.heartbeat.handleRows(header, ti, e.Rows)
+		}
+		return
+	}
+
+	switch header.EventType {
+	case replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
+		for _, row := range e.Rows {
+			printInsert(dbName, tblName, ti, row, &h.stmt)
+		}
+	case replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
+		for _, row := range e.Rows {
+			printDelete(dbName, tblName, ti, row, &h.stmt)
+		}
+	case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2, replication.PARTIAL_UPDATE_ROWS_EVENT:
+		for i := 0; i < len(e.Rows); i += 2 {
+			before := e.Rows[i]
+			after := e.Rows[i+1]
+			printUpdate(dbName, tblName, ti, before, after, &h.stmt)
+		}
+	default:
+		slog.Error("unsupported row event; its rows were NOT published", "event_type", header.EventType.String(),
+			logging.KeyDB, dbName, logging.KeyTable, tblName, "rows", len(e.Rows), binlogPos(h.file, header.LogPos))
 	}
 }
 
+// binlogPos is the binlog_pos attribute for file:pos.
+func binlogPos(file string, pos uint32) slog.Attr {
+	return slog.String(logging.KeyBinlogPos, fmt.Sprintf("%s:%d", file, pos))
+}
+
+// isRowsEventType reports whether t is an event type that carries row data.
+func isRowsEventType(t replication.EventType) bool {
+	switch t {
+	case replication.WRITE_ROWS_EVENTv0, replication.UPDATE_ROWS_EVENTv0, replication.DELETE_ROWS_EVENTv0,
+		replication.WRITE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv1,
+		replication.WRITE_ROWS_EVENTv2, replication.UPDATE_ROWS_EVENTv2, replication.DELETE_ROWS_EVENTv2,
+		replication.PARTIAL_UPDATE_ROWS_EVENT, replication.TRANSACTION_PAYLOAD_EVENT:
+		return true
+	}
+	return false
+}
+
 // --- helpers ---
 
 // Helper function to convert UTC to IST
@@ -479,14 +983,18 @@ func loadSchemaInfo(ctx context.Context, db *sql.DB, schema, table string) (*sch
 }
 
 // Updated printUpdate function
-func printUpdate(db, table string, ti *schemaInfo, before, after []interface{}) {
+func printUpdate(db, table string, ti *schemaInfo, before, after []interface{}, stmt *stmtContext) {
 	// Generate row identifier
-	rowID := generateRowIdentifier(ti, after)
+	rowID := generateRowIdentifier(db, table, ti, after)
 
 	// Collect changes
 	var changes []event.ColumnChange
 	if ti != nil {
 		for i, col := range ti.Columns {
+			if diff, ok := after[i].(*replication.JsonDiff); ok {
+				changes = append(changes, jsonDiffChange(col, before[i], diff))
+				continue
+			}
 			if !valueEqual(before[i], after[i]) {
 				changes = append(changes, event.ColumnChange{
 					Column: col,
@@ -504,29 +1012,17 @@ func printUpdate(db, table string, ti *schemaInfo, before, after []interface{})
 		DB:        db,
 		Table:     table,
 		RowKey:    rowID.Value,
-		Changes:   changes,
-	}
-
-	// Marshal the event
-	data, err := json.Marshal(e)
-	if err != nil {
-		log.Printf("error marshaling JSON: %v", err)
-		return
+		Changes:   policies.applyChanges(db, table, changes),
 	}
 
-	// Publish to Redis
-	err = publisher.PublishJSON(data, fmt.Sprintf("%s.%s:update:%v", db, table, e.RowKey))
-	if err != nil {
-		log.Printf("error publishing to Redis: %v", err)
-		return
-	}
+	emitRowEvent(e, "update", stmt)
 }
 
 // Updated printInsert function
-func printInsert(db, table string, ti *schemaInfo, row []interface{}) {
-	pkVal := pkValue(ti, row)
+func printInsert(db, table string, ti *schemaInfo, row []interface{}, stmt *stmtContext) {
+	pkVal := pkValue(db, table, ti, row)
 	if pkVal == nil {
-		log.Printf("warning: no primary key found for %s.%s", db, table)
+		slog.Warn("no primary key found", logging.KeyDB, db, logging.KeyTable, table)
 		return
 	}
 
@@ -536,29 +1032,17 @@ func printInsert(db, table string, ti *schemaInfo, row []interface{}) {
 		DB:        db,
 		Table:     table,
 		RowKey:    pkVal,
-		After:     rowAsNamedMap(ti, row),
+		After:     rowAsNamedMap(db, table, ti, row),
 	}
 
-	// Marshal the event
-	data, err := json.Marshal(e)
-	if err != nil {
-		log.Printf("error marshaling JSON: %v", err)
-		return
-	}
-
-	// Publish to Redis
-	err = publisher.PublishJSON(data, fmt.Sprintf("%s.%s:insert:%v", db, table, e.RowKey))
-	if err != nil {
-		log.Printf("error publishing to Redis: %v", err)
-		return
-	}
+	emitRowEvent(e, "insert", stmt)
 }
 
 // Updated printDelete function
-func printDelete(db, table string, ti *schemaInfo, row []interface{}) {
-	pkVal := pkValue(ti, row)
+func printDelete(db, table string, ti *schemaInfo, row []interface{}, stmt *stmtContext) {
+	pkVal := pkValue(db, table, ti, row)
 	if pkVal == nil {
-		log.Printf("warning: no primary key found for %s.%s", db, table)
+		slog.Warn("no primary key found", logging.KeyDB, db, logging.KeyTable, table)
 		return
 	}
 
@@ -568,26 +1052,29 @@ func printDelete(db, table string, ti *schemaInfo, row []interface{}) {
 		DB:        db,
 		Table:     table,
 		RowKey:    pkVal,
-		Before:    rowAsNamedMap(ti, row),
+		Before:    rowAsNamedMap(db, table, ti, row),
 		Tombstone: true,
 	}
 
-	// Marshal the event
-	data, err := json.Marshal(e)
-	if err != nil {
-		log.Printf("error marshaling JSON: %v", err)
-		return
-	}
+	emitRowEvent(e, "delete", stmt)
+}
 
-	// Publish to Redis
-	err = publisher.PublishJSON(data, fmt.Sprintf("%s.%s:delete:%v", db, table, e.RowKey))
-	if err != nil {
-		log.Printf("error publishing to Redis: %v", err)
-		return
+// emitRowEvent attaches statement metadata to e and hands it to the publish
+// pipeline.
+func emitRowEvent(e *event.RowEvent, kind string, stmt *stmtContext) {
+	if stmt != nil {
+		e.Query = stmt.query
+		e.Origin = stmt.origin
+		if !stmt.commit.IsZero() {
+			e.CommitTime = stmt.commit.UTC().Format(time.RFC3339Nano)
+		}
 	}
+	metrics.decoded.WithLabelValues(e.DB, e.Table, e.Op).Inc()
+	slog.Debug("event decoded", logging.KeyDB, e.DB, logging.KeyTable, e.Table, logging.KeyOp, e.Op, logging.KeyRowKey, rowKeyString(e.RowKey))
+	pipeline.Submit(e, kind)
 }
 
-func rowAsNamedMap(ti *schemaInfo, row []interface{}) map[string]interface{} {
+func rowAsNamedMap(db, table string, ti *schemaInfo, row []interface{}) map[string]interface{} {
 	m := make(map[string]interface{}, len(row))
 	if ti != nil && len(ti.Columns) == len(row) {
 		for i, col := range ti.Columns {
@@ -598,20 +1085,24 @@ func rowAsNamedMap(ti *schemaInfo, row []interface{}) map[string]interface{} {
 			m[fmt.Sprintf("col_%d", i+1)] = sanitize(row[i])
 		}
 	}
+	policies.applyRow(db, table, m)
 	return m
 }
 
-func pkValue(ti *schemaInfo, row []interface{}) interface{} {
+// pkValue returns the row's primary key, with column policies applied to
+// the key columns they cover.
+func pkValue(db, table string, ti *schemaInfo, row []interface{}) interface{} {
 	if ti == nil || len(ti.PKCols) == 0 || len(ti.Columns) != len(row) {
 		return nil
 	}
 	if len(ti.PKCols) == 1 {
-		return sanitize(row[ti.ColIndex[ti.PKCols[0]]])
+		c := ti.PKCols[0]
+		return policies.applyKey(db, table, c, sanitize(row[ti.ColIndex[c]]))
 	}
 	// composite key
 	out := make(map[string]interface{}, len(ti.PKCols))
 	for _, c := range ti.PKCols {
-		out[c] = sanitize(row[ti.ColIndex[c]])
+		out[c] = policies.applyKey(db, table, c, sanitize(row[ti.ColIndex[c]]))
 	}
 	return out
 }
@@ -636,10 +1127,10 @@ func valueEqual(a, b interface{}) bool {
 }
 
 // Generate a unique identifier for a row
-func generateRowIdentifier(ti *schemaInfo, row []interface{}) RowIdentifier {
+func generateRowIdentifier(db, table string, ti *schemaInfo, row []interface{}) RowIdentifier {
 	// Try primary key first
 	if ti != nil && len(ti.PKCols) > 0 && len(ti.Columns) == len(row) {
-		pkVal := pkValue(ti, row)
+		pkVal := pkValue(db, table, ti, row)
 		if pkVal != nil {
 			return RowIdentifier{
 				Value:    fmt.Sprintf("%v", pkVal),
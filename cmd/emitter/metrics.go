@@ -0,0 +1,148 @@
+package main
+
+// Prometheus metrics
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const metricsNamespace = "binlog_emitter"
+
+var metrics = newEmitterMetrics()
+
+// emitterMetrics is always updated; it is only exposed when METRICS_ADDR
+// is set.
+type emitterMetrics struct {
+	registry *prometheus.Registry
+
+	decoded       *prometheus.CounterVec
+	published     *prometheus.CounterVec
+	publishErrors *prometheus.CounterVec
+	publishTime   prometheus.Histogram
+	schemaTables  prometheus.Gauge
+	schemaLookups *prometheus.CounterVec
+	reconnects    prometheus.Counter
+	position      *prometheus.GaugeVec
+	lag           prometheus.GaugeFunc
+	lastEventTime prometheus.Gauge
+	heartbeatLag  prometheus.Gauge
+	lastHeartbeat prometheus.Gauge
+	commitToEmit  prometheus.Histogram
+
+	posFile  string
+	posGauge prometheus.Gauge
+	// Timestamp of the last binlog event read, unix seconds; lag is
+	// computed from it when scraped, so it keeps growing on a stalled
+	// stream.
+	lastEvent atomic.Int64
+}
+
+func newEmitterMetrics() *emitterMetrics {
+	m := &emitterMetrics{
+		registry: prometheus.NewRegistry(),
+		decoded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_decoded_total",
+			Help:      "Row events decoded from the binlog.",
+		}, []string{"db", "table", "op"}),
+		published: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_published_total",
+			Help:      "Row events delivered to the sink (or spooled).",
+		}, []string{"db", "table", "op"}),
+		publishErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "publish_errors_total",
+			Help:      "Row events that could not be published.",
+		}, []string{"db", "table", "op"}),
+		publishTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "publish_batch_duration_seconds",
+			Help:      "Time to publish one batch of events.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}),
+		schemaTables: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "schema_cache_tables",
+			Help:      "Tables in the schema cache of the current replication session.",
+		}),
+		schemaLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "schema_cache_lookups_total",
+			Help:      "Schema cache lookups on table map events, by result (hit, miss, error).",
+		}, []string{"result"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reconnects_total",
+			Help:      "Replication sessions restarted after an error.",
+		}),
+		position: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "binlog_position",
+			Help:      "Position of the last binlog event read, labelled with the current file.",
+		}, []string{"file"}),
+		lastEventTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_event_timestamp_seconds",
+			Help:      "Timestamp of the last binlog event read.",
+		}),
+		heartbeatLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "heartbeat_lag_seconds",
+			Help:      "Time from writing the last heartbeat row to reading it back from the binlog.",
+		}),
+		lastHeartbeat: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_heartbeat_timestamp_seconds",
+			Help:      "When the last heartbeat row was read back from the binlog.",
+		}),
+		commitToEmit: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "commit_to_emit_seconds",
+			Help:      "Time from the source committing a transaction to its events being handed to the sink.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}),
+	}
+	m.lag = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "replication_lag_seconds",
+		Help:      "Now minus the timestamp of the last binlog event read; 0 until one is read.",
+	}, m.replicationLag)
+	m.registry.MustRegister(
+		m.decoded, m.published, m.publishErrors, m.publishTime,
+		m.schemaTables, m.schemaLookups, m.reconnects,
+		m.position, m.lag, m.lastEventTime,
+		m.heartbeatLag, m.lastHeartbeat, m.commitToEmit,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// observeEvent records the position and lag of a binlog event. Events with
+// no timestamp (heartbeats, artificial rotates) only move the position.
+func (m *emitterMetrics) observeEvent(file string, logPos, timestamp uint32) {
+	if file != "" && logPos > 0 {
+		if file != m.posFile {
+			m.position.Reset()
+			m.posFile = file
+			m.posGauge = m.position.WithLabelValues(file)
+		}
+		m.posGauge.Set(float64(logPos))
+	}
+	if timestamp > 0 {
+		m.lastEventTime.Set(float64(timestamp))
+		m.lastEvent.Store(int64(timestamp))
+	}
+}
+
+func (m *emitterMetrics) replicationLag() float64 {
+	ts := m.lastEvent.Load()
+	if ts == 0 {
+		return 0
+	}
+	return max(0, time.Since(time.Unix(ts, 0)).Seconds())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReplicationLagGrowsWhileIdle(t *testing.T) {
+	m := newEmitterMetrics()
+	if lag := testutil.ToFloat64(m.lag); lag != 0 {
+		t.Errorf("lag before any event = %v, want 0", lag)
+	}
+
+	m.observeEvent("binlog.000001", 120, uint32(time.Now().Add(-time.Minute).Unix()))
+	first := testutil.ToFloat64(m.lag)
+	if first < 59 || first > 62 {
+		t.Fatalf("lag = %v, want about 60s", first)
+	}
+	// No further events: the lag is computed when scraped, not frozen.
+	time.Sleep(50 * time.Millisecond)
+	if lag := testutil.ToFloat64(m.lag); lag <= first {
+		t.Errorf("lag stayed at %v on an idle stream", lag)
+	}
+
+	// Events without a timestamp don't reset it.
+	m.observeEvent("binlog.000001", 200, 0)
+	if lag := testutil.ToFloat64(m.lag); lag < first {
+		t.Errorf("lag dropped to %v after an untimed event", lag)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+// TLS and credential files for the MySQL connections
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// mysqlTLSConfigName is the name the TLS config is registered under with the
+// database/sql driver, referenced from the DSN.
+const mysqlTLSConfigName = "binlog-emitter"
+
+// loadMySQLTLS builds the TLS config shared by the schema connection and the
+// replication syncer from DB_TLS, DB_TLS_CA_FILE, DB_TLS_CERT_FILE,
+// DB_TLS_KEY_FILE, DB_TLS_SERVER_NAME and DB_TLS_SKIP_VERIFY. It returns nil
+// when TLS is off. Setting any of the files turns TLS on.
+func loadMySQLTLS(host string) (*tls.Config, error) {
+	caFile := os.Getenv("DB_TLS_CA_FILE")
+	certFile := os.Getenv("DB_TLS_CERT_FILE")
+	keyFile := os.Getenv("DB_TLS_KEY_FILE")
+
+	enabled := caFile != "" || certFile != "" || keyFile != ""
+	if v := os.Getenv("DB_TLS"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_TLS: %q", v)
+		}
+		enabled = b
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: os.Getenv("DB_TLS_SERVER_NAME"),
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	if v := os.Getenv("DB_TLS_SKIP_VERIFY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_TLS_SKIP_VERIFY: %q", v)
+		}
+		cfg.InsecureSkipVerify = b
+	}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("read DB_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("DB_TLS_CA_FILE %s: no certificates found", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load MySQL client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if err := mysqldriver.RegisterTLSConfig(mysqlTLSConfigName, cfg); err != nil {
+		return nil, fmt.Errorf("register MySQL TLS config: %w", err)
+	}
+	return cfg, nil
+}
+
+// readSecretFile returns the contents of path without the trailing newline
+// editors and secret mounts tend to add.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+// systemd readiness, status and watchdog notifications
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/sdnotify"
+)
+
+// runNotifier speaks sd_notify when started by systemd (NOTIFY_SOCKET set):
+// READY once replication is established, periodic STATUS lines, and
+// WATCHDOG pings only while binlog events (heartbeats included) keep
+// arriving, and our heartbeat rows with them when HEARTBEAT_TABLE is set, so
+// a hung stream is restarted by WatchdogSec=.
+func runNotifier(ctx context.Context, maxEventAge time.Duration) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	watchdog := sdnotify.WatchdogInterval()
+	interval := 10 * time.Second
+	if watchdog > 0 && watchdog/2 < interval {
+		interval = watchdog / 2
+	}
+
+	notify := func(state string) {
+		if _, err := sdnotify.Notify(state); err != nil {
+			slog.Warn("sd_notify", logging.Err(err))
+		}
+	}
+
+	ready := false
+	ticker := time.NewTicker(time.Second) // poll quickly until ready
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			notify(sdnotify.Stopping)
+			return
+		case <-ticker.C:
+		}
+
+		health.mu.Lock()
+		connected := health.connected
+		health.mu.Unlock()
+		age := health.eventAge()
+		alive := age < maxEventAge
+		if health.heartbeats {
+			alive = alive && health.heartbeatAge() < maxEventAge
+		}
+
+		if !ready && connected {
+			notify(sdnotify.Ready)
+			ready = true
+			ticker.Reset(interval)
+		}
+		notify(sdnotify.Status(emitterStatus(connected, age)))
+		if ready && watchdog > 0 && alive {
+			notify(sdnotify.Watchdog)
+		}
+	}
+}
+
+func emitterStatus(connected bool, age time.Duration) string {
+	if !connected {
+		return "Connecting to MySQL"
+	}
+	cp := "none"
+	if c := pipeline.Checkpoint(); c.Name != "" {
+		cp = c.String()
+	}
+	s := fmt.Sprintf("Streaming; checkpoint %s; last event %s ago", cp, age.Truncate(time.Second))
+	if health.heartbeats {
+		s += fmt.Sprintf("; last heartbeat %s ago", health.heartbeatAge().Truncate(time.Second))
+	}
+	return s
+}
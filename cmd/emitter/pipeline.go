@@ -0,0 +1,273 @@
+package main
+
+// Ordered asynchronous publish pipeline
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/sink"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+const (
+	defaultPublishWorkers = 4
+	defaultPublishBatch   = 100
+	defaultPublishQueue   = 1024
+)
+
+var pipeline *Pipeline
+
+type pipelineItem struct {
+	seq     uint64
+	ev      *event.RowEvent
+	eventID string
+}
+
+type checkpointMark struct {
+	seq uint64
+	pos mysql.Position
+}
+
+// Pipeline decouples the binlog reader from publishing. Events are sharded
+// by row key onto worker goroutines, so changes to the same row are
+// published in binlog order while different rows proceed concurrently. Each
+// worker marshals and publishes whatever is queued in one call to the sink.
+//
+// Every submitted event gets a sequence number. A checkpoint (a binlog
+// position at a transaction boundary) only becomes current once every event
+// submitted before it has been handled.
+type Pipeline struct {
+	ctx    context.Context
+	pub    *Publisher
+	shards []chan *pipelineItem
+	batch  int
+	wg     sync.WaitGroup
+
+	mu           sync.Mutex
+	nextSeq      uint64
+	low          uint64 // every seq below low has been handled
+	done         map[uint64]struct{}
+	marks        []checkpointMark
+	checkpoint   mysql.Position
+	onCheckpoint func(mysql.Position)
+}
+
+func NewPipeline(ctx context.Context, pub *Publisher, workers, batch, queue int, onCheckpoint func(mysql.Position)) *Pipeline {
+	p := &Pipeline{
+		ctx:          ctx,
+		pub:          pub,
+		shards:       make([]chan *pipelineItem, workers),
+		batch:        batch,
+		done:         make(map[uint64]struct{}),
+		onCheckpoint: onCheckpoint,
+	}
+	for i := range p.shards {
+		p.shards[i] = make(chan *pipelineItem, queue)
+		p.wg.Add(1)
+		go p.worker(p.shards[i])
+	}
+	return p
+}
+
+// Submit queues an event for publishing. It blocks when the event's shard
+// is full, which in turn pauses binlog reading. Events submitted after the
+// pipeline's context is done are dropped; they are past the last checkpoint.
+func (p *Pipeline) Submit(e *event.RowEvent, kind string) {
+	rowKey := fmt.Sprintf("%s.%s:%v", e.DB, e.Table, e.RowKey)
+
+	p.mu.Lock()
+	seq := p.nextSeq
+	p.nextSeq++
+	p.mu.Unlock()
+
+	item := &pipelineItem{
+		seq:     seq,
+		ev:      e,
+		eventID: fmt.Sprintf("%s.%s:%s:%v", e.DB, e.Table, kind, e.RowKey),
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(rowKey))
+	select {
+	case p.shards[h.Sum32()%uint32(len(p.shards))] <- item:
+	case <-p.ctx.Done():
+	}
+}
+
+// Mark records pos as a checkpoint candidate covering everything submitted
+// so far.
+func (p *Pipeline) Mark(pos mysql.Position) {
+	p.mu.Lock()
+	p.marks = append(p.marks, checkpointMark{seq: p.nextSeq, pos: pos})
+	cp, advanced := p.advanceLocked()
+	p.mu.Unlock()
+	if advanced && p.onCheckpoint != nil {
+		p.onCheckpoint(cp)
+	}
+}
+
+// Checkpoint returns the newest position before which everything has been
+// published.
+func (p *Pipeline) Checkpoint() mysql.Position {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.checkpoint
+}
+
+// Close stops accepting events and waits for queued ones to be published.
+func (p *Pipeline) Close() {
+	for _, ch := range p.shards {
+		close(ch)
+	}
+	p.wg.Wait()
+}
+
+func (p *Pipeline) worker(ch chan *pipelineItem) {
+	defer p.wg.Done()
+
+	items := make([]*pipelineItem, 0, p.batch)
+	for item := range ch {
+		items = append(items[:0], item)
+	fill:
+		for len(items) < p.batch {
+			select {
+			case next, ok := <-ch:
+				if !ok {
+					break fill
+				}
+				items = append(items, next)
+			default:
+				break fill
+			}
+		}
+		p.publish(items)
+	}
+}
+
+func (p *Pipeline) publish(items []*pipelineItem) {
+	msgs := make([]sink.Message, 0, len(items))
+	for _, it := range items {
+		traceEmit(it.ev)
+		data, err := json.Marshal(it.ev)
+		if err != nil {
+			metrics.publishErrors.WithLabelValues(it.ev.DB, it.ev.Table, it.ev.Op).Inc()
+			slog.Error("marshal event", logging.KeyDB, it.ev.DB, logging.KeyTable, it.ev.Table, logging.KeyOp, it.ev.Op, logging.Err(err))
+			continue
+		}
+		msgs = append(msgs, sink.Message{
+			ID:      it.eventID,
+			DB:      it.ev.DB,
+			Table:   it.ev.Table,
+			Op:      it.ev.Op,
+			RowKey:  rowKeyString(it.ev.RowKey),
+			Payload: data,
+		})
+	}
+
+	start := time.Now()
+	errs := p.pub.PublishBatch(msgs)
+	took := time.Since(start)
+	metrics.publishTime.Observe(took.Seconds())
+	for i, err := range errs {
+		m := msgs[i]
+		if err != nil {
+			metrics.publishErrors.WithLabelValues(m.DB, m.Table, m.Op).Inc()
+			slog.Error("publish event", "event_id", m.ID, logging.KeyDB, m.DB, logging.KeyTable, m.Table,
+				logging.KeyOp, m.Op, logging.KeyRowKey, m.RowKey, logging.Err(err))
+			continue
+		}
+		metrics.published.WithLabelValues(m.DB, m.Table, m.Op).Inc()
+		slog.Debug("event published", "event_id", m.ID, logging.KeyDB, m.DB, logging.KeyTable, m.Table,
+			logging.KeyOp, m.Op, logging.KeyRowKey, m.RowKey, logging.KeyDuration, took)
+	}
+
+	// Failed events are logged and dropped (or spooled, if enabled); either
+	// way they no longer hold back the checkpoint.
+	p.mu.Lock()
+	for _, it := range items {
+		p.done[it.seq] = struct{}{}
+	}
+	cp, advanced := p.advanceLocked()
+	p.mu.Unlock()
+	if advanced && p.onCheckpoint != nil {
+		p.onCheckpoint(cp)
+	}
+}
+
+func (p *Pipeline) advanceLocked() (mysql.Position, bool) {
+	for {
+		if _, ok := p.done[p.low]; !ok {
+			break
+		}
+		delete(p.done, p.low)
+		p.low++
+	}
+
+	advanced := false
+	for len(p.marks) > 0 && p.marks[0].seq <= p.low {
+		p.checkpoint = p.marks[0].pos
+		p.marks = p.marks[1:]
+		advanced = true
+	}
+	return p.checkpoint, advanced
+}
+
+// checkpointSaver returns a checkpoint callback that persists positions to
+// path at most once per second, or nil when path is empty. The final
+// position is written on shutdown.
+func checkpointSaver(path string) func(mysql.Position) {
+	if path == "" {
+		return nil
+	}
+	var (
+		mu      sync.Mutex
+		last    time.Time
+		lastPos mysql.Position
+	)
+	return func(pos mysql.Position) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Workers report concurrently; never move the file backwards.
+		if pos.Compare(lastPos) <= 0 || time.Since(last) < time.Second {
+			return
+		}
+		last, lastPos = time.Now(), pos
+		if err := saveCheckpoint(path, pos); err != nil {
+			slog.Error("save checkpoint", logging.Err(err))
+		}
+	}
+}
+
+// loadCheckpoint reads a position written by saveCheckpoint.
+func loadCheckpoint(path string) (mysql.Position, bool, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mysql.Position{}, false, nil
+	}
+	if err != nil {
+		return mysql.Position{}, false, err
+	}
+	var pos mysql.Position
+	if _, err := fmt.Sscanf(string(b), "%s %d", &pos.Name, &pos.Pos); err != nil {
+		return mysql.Position{}, false, fmt.Errorf("parse checkpoint %s: %w", path, err)
+	}
+	return pos, true, nil
+}
+
+// saveCheckpoint writes pos atomically via a temp file and rename.
+func saveCheckpoint(path string, pos mysql.Position) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%s %d\n", pos.Name, pos.Pos)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
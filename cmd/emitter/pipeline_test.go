@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/sink"
+	"mysql_changelog_publisher/internal/sink/sinktest"
+
+	"github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// gateSink holds back batches with a message for row key hold until
+// release is closed.
+type gateSink struct {
+	*sinktest.Fake
+	hold    string
+	release chan struct{}
+}
+
+func (g *gateSink) Publish(ctx context.Context, msgs []sink.Message) []error {
+	for _, m := range msgs {
+		if m.RowKey == g.hold {
+			<-g.release
+			break
+		}
+	}
+	return g.Fake.Publish(ctx, msgs)
+}
+
+func leadUpdate(row int) *event.RowEvent {
+	return &event.RowEvent{DB: "crm", Table: "leads", Op: "update", RowKey: row}
+}
+
+func TestPipelineKeepsRowOrder(t *testing.T) {
+	out := sinktest.New("a")
+	p := NewPipeline(context.Background(), NewPublisher(context.Background(), out, nil, nil), 4, 3, 16, nil)
+	const rows, versions = 8, 20
+	for v := 0; v < versions; v++ {
+		for row := 0; row < rows; row++ {
+			// The kind carries the version so the sink can tell them apart.
+			p.Submit(leadUpdate(row), fmt.Sprintf("v%d", v))
+		}
+	}
+	p.Close()
+
+	got := out.Delivered()
+	if len(got) != rows*versions {
+		t.Fatalf("delivered %d events, want %d", len(got), rows*versions)
+	}
+	last := map[int]int{}
+	for _, id := range got {
+		var row, v int
+		if _, err := fmt.Sscanf(id, "crm.leads:v%d:%d", &v, &row); err != nil {
+			t.Fatalf("event id %q: %v", id, err)
+		}
+		if prev, ok := last[row]; ok && v != prev+1 {
+			t.Fatalf("row %d: version %d published after %d", row, v, prev)
+		}
+		last[row] = v
+	}
+}
+
+func TestPipelineCheckpointWaitsForEarlierEvents(t *testing.T) {
+	out := &gateSink{Fake: sinktest.New("a"), hold: "1", release: make(chan struct{})}
+	var (
+		mu  sync.Mutex
+		cps []mysql.Position
+	)
+	p := NewPipeline(context.Background(), NewPublisher(context.Background(), out, nil, nil), 4, 1, 16, func(pos mysql.Position) {
+		mu.Lock()
+		cps = append(cps, pos)
+		mu.Unlock()
+	})
+	checkpoints := func() []mysql.Position {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]mysql.Position(nil), cps...)
+	}
+
+	p.Submit(leadUpdate(1), "update") // held by the sink
+	p.Mark(mysql.Position{Name: "binlog.000001", Pos: 100})
+	p.Submit(leadUpdate(2), "update")
+	p.Mark(mysql.Position{Name: "binlog.000001", Pos: 200})
+
+	// Row 2 is published, but row 1, submitted first, isn't yet.
+	waitFor(t, func() bool { return len(out.Delivered()) == 1 })
+	time.Sleep(20 * time.Millisecond)
+	if cp := p.Checkpoint(); cp.Pos != 0 || len(checkpoints()) != 0 {
+		t.Fatalf("checkpoint moved to %v before an earlier event was published", cp)
+	}
+
+	close(out.release)
+	waitFor(t, func() bool { return p.Checkpoint().Pos == 200 })
+	p.Mark(mysql.Position{Name: "binlog.000002", Pos: 4})
+	p.Close()
+
+	got := checkpoints()
+	for i := 1; i < len(got); i++ {
+		if got[i].Compare(got[i-1]) <= 0 {
+			t.Fatalf("checkpoints went backwards: %v", got)
+		}
+	}
+	if n := len(got); n == 0 || got[n-1] != (mysql.Position{Name: "binlog.000002", Pos: 4}) {
+		t.Errorf("checkpoints = %v, want the last mark reported", got)
+	}
+}
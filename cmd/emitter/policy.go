@@ -0,0 +1,200 @@
+package main
+
+// Column policies for sensitive data (drop, mask, hash, tokenize)
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"mysql_changelog_publisher/internal/event"
+)
+
+const defaultMaskReveal = 4
+
+type policyAction int
+
+const (
+	policyDrop policyAction = iota
+	policyMask
+	policyHash
+	policyTokenize
+)
+
+type columnRule struct {
+	action policyAction
+	reveal int // trailing characters left visible by policyMask
+}
+
+// columnPolicies holds per-table column rules keyed by "db.table.column" or
+// "table.column". The more specific key wins.
+type columnPolicies struct {
+	rules    map[string]columnRule
+	hashSalt []byte
+	tokenKey []byte
+}
+
+var policies *columnPolicies
+
+// loadColumnPolicies parses COLUMN_POLICIES, a comma-separated list of
+// [db.]table.column=policy entries where policy is drop, mask[:N], hash or
+// tokenize. It returns nil when no policies are configured.
+func loadColumnPolicies() (*columnPolicies, error) {
+	spec := strings.TrimSpace(os.Getenv("COLUMN_POLICIES"))
+	if spec == "" {
+		return nil, nil
+	}
+
+	p := &columnPolicies{
+		rules:    map[string]columnRule{},
+		hashSalt: []byte(os.Getenv("COLUMN_HASH_SALT")),
+		tokenKey: []byte(os.Getenv("COLUMN_TOKEN_KEY")),
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		target, policy, ok := strings.Cut(entry, "=")
+		target = strings.TrimSpace(target)
+		if !ok || strings.Count(target, ".") < 1 || strings.Count(target, ".") > 2 {
+			return nil, fmt.Errorf("invalid COLUMN_POLICIES entry %q (want [db.]table.column=policy)", entry)
+		}
+
+		name, arg, _ := strings.Cut(strings.ToLower(strings.TrimSpace(policy)), ":")
+		var rule columnRule
+		switch name {
+		case "drop":
+			rule.action = policyDrop
+		case "mask":
+			rule.action = policyMask
+			rule.reveal = defaultMaskReveal
+			if arg != "" {
+				n, err := strconv.Atoi(arg)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid mask reveal in COLUMN_POLICIES entry %q", entry)
+				}
+				rule.reveal = n
+			}
+		case "hash":
+			rule.action = policyHash
+			if len(p.hashSalt) == 0 {
+				return nil, fmt.Errorf("COLUMN_HASH_SALT is required for hash policy (%s)", target)
+			}
+		case "tokenize":
+			rule.action = policyTokenize
+			if len(p.tokenKey) == 0 {
+				return nil, fmt.Errorf("COLUMN_TOKEN_KEY is required for tokenize policy (%s)", target)
+			}
+		default:
+			return nil, fmt.Errorf("unknown policy %q in COLUMN_POLICIES entry %q", name, entry)
+		}
+		p.rules[target] = rule
+	}
+
+	return p, nil
+}
+
+func (p *columnPolicies) lookup(db, table, column string) (columnRule, bool) {
+	if p == nil {
+		return columnRule{}, false
+	}
+	if r, ok := p.rules[db+"."+table+"."+column]; ok {
+		return r, true
+	}
+	r, ok := p.rules[table+"."+column]
+	return r, ok
+}
+
+// applyRow rewrites a named row image in place.
+func (p *columnPolicies) applyRow(db, table string, m map[string]interface{}) {
+	if p == nil {
+		return
+	}
+	for col, v := range m {
+		rule, ok := p.lookup(db, table, col)
+		if !ok {
+			continue
+		}
+		if rule.action == policyDrop {
+			delete(m, col)
+			continue
+		}
+		m[col] = p.transform(rule, v)
+	}
+}
+
+// applyChanges rewrites column changes. Dropped columns disappear from the
+// list entirely; JSON patches are removed for any protected column since
+// they would leak the raw value.
+func (p *columnPolicies) applyChanges(db, table string, changes []event.ColumnChange) []event.ColumnChange {
+	if p == nil {
+		return changes
+	}
+	out := changes[:0]
+	for _, c := range changes {
+		rule, ok := p.lookup(db, table, c.Column)
+		if !ok {
+			out = append(out, c)
+			continue
+		}
+		if rule.action == policyDrop {
+			continue
+		}
+		c.From = p.transform(rule, c.From)
+		c.To = p.transform(rule, c.To)
+		c.Patch = nil
+		out = append(out, c)
+	}
+	return out
+}
+
+// applyKey rewrites a primary key column value for row_key and event ids.
+// A key column cannot be left out of the key, so drop hashes it instead.
+func (p *columnPolicies) applyKey(db, table, column string, v interface{}) interface{} {
+	rule, ok := p.lookup(db, table, column)
+	if !ok {
+		return v
+	}
+	if rule.action == policyDrop {
+		rule.action = policyHash
+	}
+	return p.transform(rule, v)
+}
+
+func (p *columnPolicies) transform(rule columnRule, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	s := fmt.Sprintf("%v", v)
+	switch rule.action {
+	case policyMask:
+		return maskValue(s, rule.reveal)
+	case policyHash:
+		h := sha256.New()
+		h.Write(p.hashSalt)
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	case policyTokenize:
+		mac := hmac.New(sha256.New, p.tokenKey)
+		mac.Write([]byte(s))
+		return "tok_" + hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return nil
+}
+
+// maskValue replaces all but the last reveal characters with '*'.
+func maskValue(s string, reveal int) string {
+	r := []rune(s)
+	if reveal > len(r) {
+		reveal = len(r)
+	}
+	for i := 0; i < len(r)-reveal; i++ {
+		r[i] = '*'
+	}
+	return string(r)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func setPolicies(t *testing.T, spec string) {
+	t.Helper()
+	t.Setenv("COLUMN_POLICIES", spec)
+	t.Setenv("COLUMN_HASH_SALT", "salt")
+	t.Setenv("COLUMN_TOKEN_KEY", "key")
+	p, err := loadColumnPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := policies
+	policies = p
+	t.Cleanup(func() { policies = old })
+}
+
+func TestQueryRedactedWithPolicies(t *testing.T) {
+	t.Setenv("DB_USER", "u")
+	t.Setenv("DB_HOST", "localhost")
+	t.Setenv("DB_NAME", "crm")
+	t.Setenv("INCLUDE_QUERY", "true")
+	t.Setenv("QUERY_REDACT", "none")
+	q := "UPDATE leads SET phone = '+15551234567', score = 42 WHERE id = 7"
+
+	t.Setenv("COLUMN_POLICIES", "")
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Query.format(q); got != q {
+		t.Fatalf("without policies: query = %q, want it unchanged", got)
+	}
+
+	t.Setenv("COLUMN_POLICIES", "leads.phone=mask")
+	cfg, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := cfg.Query.format(q)
+	for _, leak := range []string{"5551234567", "42", "7"} {
+		if strings.Contains(got, leak) {
+			t.Errorf("query %q leaks %q", got, leak)
+		}
+	}
+	if want := "UPDATE leads SET phone = ?, score = ? WHERE id = ?"; got != want {
+		t.Errorf("query = %q, want %q", got, want)
+	}
+
+	// QUERY_REDACT=literals must not redact twice.
+	t.Setenv("QUERY_REDACT", "literals")
+	if cfg, err = loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cfg.Query.Redactors); n != 1 {
+		t.Errorf("%d redactors, want 1", n)
+	}
+}
+
+func TestRowKeyPolicies(t *testing.T) {
+	setPolicies(t, "leads.email=hash,crm.leads.ssn=drop,accounts.tenant=mask:2")
+
+	leads := &schemaInfo{
+		Columns:  []string{"email", "name"},
+		ColIndex: map[string]int{"email": 0, "name": 1},
+		PKCols:   []string{"email"},
+	}
+	row := []interface{}{[]byte("a@example.com"), "Ann"}
+	key := pkValue("crm", "leads", leads, row)
+	if s := fmt.Sprint(key); strings.Contains(s, "a@example.com") || len(s) != 64 {
+		t.Errorf("hashed key = %q", s)
+	}
+	if id := generateRowIdentifier("crm", "leads", leads, row); id.Value != fmt.Sprint(key) {
+		t.Errorf("row identifier %q, want the policy-applied key %q", id.Value, key)
+	}
+	if key2 := pkValue("crm", "leads", leads, []interface{}{"a@example.com", "Bob"}); key2 != key {
+		t.Errorf("same email gave keys %v and %v", key, key2)
+	}
+
+	// A dropped key column is hashed: it still tells rows apart.
+	ssn := &schemaInfo{Columns: []string{"ssn"}, ColIndex: map[string]int{"ssn": 0}, PKCols: []string{"ssn"}}
+	a, b := pkValue("crm", "leads", ssn, []interface{}{"123-45-6789"}), pkValue("crm", "leads", ssn, []interface{}{"987-65-4321"})
+	if fmt.Sprint(a) == "123-45-6789" || a == b {
+		t.Errorf("dropped key column: keys %v and %v", a, b)
+	}
+	if got := pkValue("other", "leads", ssn, []interface{}{"123-45-6789"}); got != "123-45-6789" {
+		t.Errorf("db-qualified policy applied to another db: %v", got)
+	}
+
+	// Composite keys: only the covered column changes.
+	acc := &schemaInfo{
+		Columns:  []string{"tenant", "id"},
+		ColIndex: map[string]int{"tenant": 0, "id": 1},
+		PKCols:   []string{"tenant", "id"},
+	}
+	got := pkValue("crm", "accounts", acc, []interface{}{"acme", int64(9)}).(map[string]interface{})
+	if got["tenant"] != "**me" || got["id"] != int64(9) {
+		t.Errorf("composite key = %v", got)
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+// Publisher: payload wrapping, sink delivery and spooling
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"mysql_changelog_publisher/internal/envelope"
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/redisconn"
+	"mysql_changelog_publisher/internal/sink"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultSinks            = "redis"
+	defaultSinkFilePath     = "events.jsonl"
+	defaultSinkFileMaxBytes = int64(100 << 20) // 100 MiB
+	defaultSinkFileMaxFiles = 10
+	defaultNATSURL          = "nats://127.0.0.1:4222"
+	defaultNATSPrefix       = "binlog"
+	defaultMQTTBroker       = "tcp://127.0.0.1:1883"
+	defaultMQTTClientID     = "binlog-emitter"
+	defaultMQTTTopic        = "cdc/{db}/{table}/{op}"
+	defaultMQTTQoS          = 1
+
+	defaultMessageLogMaxBytes = int64(100 << 20) // 100 MiB
+	defaultMessageLogMaxFiles = 10
+
+	// spoolDrainBatch is how many spooled events are resent in one sink call
+	// and acked with one cursor write.
+	spoolDrainBatch = 100
+)
+
+// Publisher encrypts and signs events as configured and hands them to the
+// sink. With spools configured, events a sink target rejects, and every
+// later event for that target until its spool is drained, are written to
+// disk instead so order is kept; the other targets keep receiving events
+// directly.
+type Publisher struct {
+	sink    sink.Sink
+	ctx     context.Context
+	logger  *EventLogger
+	wrapper *envelope.Wrapper // optional; seals and signs payloads
+
+	// Optional: a spool per sink target, holding its events while it is
+	// unavailable.
+	targets     []string
+	spools      map[string]*Spool
+	spoolNotify map[string]chan struct{}
+}
+
+// NewPublisher returns a publisher whose sink calls are cancelled with ctx.
+func NewPublisher(ctx context.Context, s sink.Sink, logger *EventLogger, wrapper *envelope.Wrapper) *Publisher {
+	return &Publisher{
+		sink:    s,
+		ctx:     ctx,
+		logger:  logger,
+		wrapper: wrapper,
+	}
+}
+
+// buildSink creates the sinks listed in SINKS, fanning out when there is
+// more than one.
+func buildSink(cfg *Config) (sink.Sink, error) {
+	var sinks []sink.Sink
+	for _, name := range cfg.Sinks {
+		switch strings.ToLower(name) {
+		case "redis":
+			rs, err := redisSink(cfg)
+			if err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, rs)
+		case "file":
+			fs, err := sink.NewFile(cfg.SinkFilePath, cfg.SinkFileMaxBytes, cfg.SinkFileMaxFiles)
+			if err != nil {
+				return nil, fmt.Errorf("file sink: %w", err)
+			}
+			sinks = append(sinks, fs)
+		case "stdout":
+			sinks = append(sinks, sink.NewStdout())
+		case "nats":
+			ns, err := sink.NewNATS(context.Background(), sink.NATSOptions{
+				URL:       cfg.NATSURL,
+				CredsFile: cfg.NATSCredsFile,
+				Prefix:    cfg.NATSPrefix,
+				Stream:    cfg.NATSStream,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("nats sink: %w", err)
+			}
+			sinks = append(sinks, ns)
+		case "mqtt":
+			ms, err := sink.NewMQTT(cfg.MQTT)
+			if err != nil {
+				return nil, fmt.Errorf("mqtt sink: %w", err)
+			}
+			sinks = append(sinks, ms)
+		default:
+			return nil, fmt.Errorf("unknown sink %q in SINKS", name)
+		}
+	}
+	switch len(sinks) {
+	case 0:
+		return nil, fmt.Errorf("SINKS is empty")
+	case 1:
+		return sinks[0], nil
+	default:
+		return sink.NewMulti(sink.All, sinks...), nil
+	}
+}
+
+// redisTarget is one Redis instance events are published to.
+type redisTarget struct {
+	Name    string
+	Options redisconn.Options
+	Channel string
+}
+
+// redisSink publishes to the Redis configured by REDIS_URL/REDIS_ADDR, or to
+// every REDIS_TARGETS entry under REDIS_TARGET_POLICY.
+func redisSink(cfg *Config) (sink.Sink, error) {
+	if len(cfg.RedisTargets) == 0 {
+		client, err := cfg.Redis.NewClient()
+		if err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		slog.Info("Redis", "redis", cfg.Redis.String(), "channel", cfg.RedisChannel)
+		return sink.NewRedis("redis", client, cfg.RedisChannel), nil
+	}
+	targets := make([]sink.Sink, 0, len(cfg.RedisTargets))
+	for _, t := range cfg.RedisTargets {
+		client, err := t.Options.NewClient()
+		if err != nil {
+			return nil, fmt.Errorf("redis target %s: %w", t.Name, err)
+		}
+		slog.Info("Redis target", "target", t.Name, "redis", t.Options.String(), "channel", t.Channel)
+		targets = append(targets, sink.NewRedis("redis["+t.Name+"]", client, t.Channel))
+	}
+	if len(targets) == 1 {
+		return targets[0], nil
+	}
+	return sink.NewMulti(cfg.RedisTargetPolicy, targets...), nil
+}
+
+// parseRedisTargets parses REDIS_TARGETS: comma-separated entries of the form
+// [name=]redis[s]://[[user]:password@]host:port[/db][?channel=name]. The name
+// defaults to host:port and the channel to defaultChannel; TLS files and
+// settings apply to rediss:// entries.
+func parseRedisTargets(s, defaultChannel string, tlsOpts redisconn.TLSOptions) ([]redisTarget, error) {
+	var targets []redisTarget
+	seen := make(map[string]bool)
+	tlsOpts.Enabled = false // only rediss:// entries use TLS
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var name string
+		if eq, scheme := strings.Index(entry, "="), strings.Index(entry, "://"); eq >= 0 && eq < scheme {
+			name, entry = strings.TrimSpace(entry[:eq]), strings.TrimSpace(entry[eq+1:])
+		}
+
+		u, err := url.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_TARGETS entry %q: %w", entry, err)
+		}
+		q := u.Query()
+		channel := q.Get("channel")
+		if channel == "" {
+			channel = defaultChannel
+		}
+		q.Del("channel")
+		u.RawQuery = q.Encode()
+		opts, err := redis.ParseURL(u.String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_TARGETS entry %q: %w", entry, err)
+		}
+
+		if name == "" {
+			name = opts.Addr
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate REDIS_TARGETS name %q", name)
+		}
+		seen[name] = true
+		targets = append(targets, redisTarget{
+			Name:    name,
+			Options: redisconn.Options{URL: u.String(), TLS: tlsOpts},
+			Channel: channel,
+		})
+	}
+	return targets, nil
+}
+
+// SinkHealth reports per-sink health when publishing to several sinks or
+// Redis targets, and nil otherwise.
+func (p *Publisher) SinkHealth() []sink.Health {
+	if hr, ok := p.sink.(sink.HealthReporter); ok {
+		return hr.Health()
+	}
+	return nil
+}
+
+// Ping checks the sink's connection, if it has one.
+func (p *Publisher) Ping(ctx context.Context) error {
+	if pinger, ok := p.sink.(sink.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// PublishBatch publishes msgs, whose payloads are plain event JSON, in one
+// call to the sink. It returns one error per message; nil means the message
+// was delivered or spooled for every target that did not take it.
+func (p *Publisher) PublishBatch(msgs []sink.Message) []error {
+	wrapped, errs := p.wrapAll(msgs)
+	if p.spools == nil {
+		p.deliver(wrapped, msgs, errs)
+		return errs
+	}
+
+	// Targets with spooled events get new ones spooled behind them, in
+	// order; the others are sent to directly.
+	var live, backlog []string
+	for _, t := range p.targets {
+		if p.spools[t].Pending() {
+			backlog = append(backlog, t)
+		} else {
+			live = append(live, t)
+		}
+	}
+
+	sendErrs := slices.Clone(errs)
+	if len(live) > 0 {
+		out := wrapped
+		if len(backlog) > 0 {
+			out = make([]sink.Message, len(wrapped))
+			for i, m := range wrapped {
+				m.Targets = live
+				out[i] = m
+			}
+		}
+		p.deliver(out, msgs, sendErrs)
+	}
+
+	spoolFor := make(map[string][]int, len(p.targets)) // message indexes by target
+	for i, err := range sendErrs {
+		if errs[i] != nil {
+			// Not wrapped; retrying won't help.
+			continue
+		}
+		var failed []string
+		var te *sink.TargetsError
+		switch {
+		case errors.As(err, &te):
+			failed = te.Failed
+		case err != nil:
+			failed = live
+		}
+		for _, t := range slices.Concat(backlog, failed) {
+			spoolFor[t] = append(spoolFor[t], i)
+		}
+	}
+
+	for _, t := range p.targets {
+		idx := spoolFor[t]
+		if len(idx) == 0 {
+			continue
+		}
+		if !slices.Contains(backlog, t) {
+			slog.Warn("publish failed, spooling events until the sink recovers",
+				"spool", p.spools[t].dir, "sink", t, "events", len(idx), logging.Err(sendErrs[idx[0]]))
+		}
+		p.spoolMsgs(t, wrapped, idx, errs)
+	}
+	return errs
+}
+
+// send wraps msgs and delivers them to the sink.
+func (p *Publisher) send(msgs []sink.Message) []error {
+	wrapped, errs := p.wrapAll(msgs)
+	p.deliver(wrapped, msgs, errs)
+	return errs
+}
+
+// wrapAll returns msgs with their payloads wrapped; errs holds the failures,
+// which retrying won't fix.
+func (p *Publisher) wrapAll(msgs []sink.Message) (wrapped []sink.Message, errs []error) {
+	wrapped = make([]sink.Message, len(msgs))
+	errs = make([]error, len(msgs))
+	for i, m := range msgs {
+		m.Payload, errs[i] = p.wrap(m.Payload)
+		wrapped[i] = m
+	}
+	return wrapped, errs
+}
+
+// deliver publishes the messages of out whose entry in errs is nil and sets
+// it to the outcome, logging each delivered message with the payload of
+// its counterpart in logged.
+func (p *Publisher) deliver(out, logged []sink.Message, errs []error) {
+	batch := make([]sink.Message, 0, len(out))
+	idx := make([]int, 0, len(out))
+	for i, m := range out {
+		if errs[i] == nil {
+			batch = append(batch, m)
+			idx = append(idx, i)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	for j, err := range p.sink.Publish(p.ctx, batch) {
+		i := idx[j]
+		errs[i] = err
+		if err == nil && p.logger != nil {
+			p.logger.Log(logged[i].ID, logged[i].Payload)
+		}
+	}
+}
+
+// payloadError marks failures to encrypt or sign, which retrying won't fix.
+type payloadError struct{ err error }
+
+func (e *payloadError) Error() string { return e.err.Error() }
+func (e *payloadError) Unwrap() error { return e.err }
+
+// wrap encrypts and signs a payload as configured.
+func (p *Publisher) wrap(jsonBytes []byte) ([]byte, error) {
+	payload, err := p.wrapper.Wrap(jsonBytes)
+	if err != nil {
+		return nil, &payloadError{err}
+	}
+	return payload, nil
+}
+
+// messageFor rebuilds routing fields for an event known only by its id and
+// JSON, as when draining records spooled by older versions.
+func messageFor(eventID string, jsonBytes []byte) sink.Message {
+	var ev struct {
+		DB     string      `json:"db"`
+		Table  string      `json:"table"`
+		Op     string      `json:"op"`
+		RowKey interface{} `json:"row_key"`
+	}
+	_ = json.Unmarshal(jsonBytes, &ev)
+	return sink.Message{
+		ID:      eventID,
+		DB:      ev.DB,
+		Table:   ev.Table,
+		Op:      ev.Op,
+		RowKey:  rowKeyString(ev.RowKey),
+		Payload: jsonBytes,
+	}
+}
+
+func rowKeyString(v interface{}) string {
+	return fmt.Sprintf("%v", v)
+}
+
+// UseSpools enables spooling to spools, which must hold a spool for every
+// target of the sink (see sink.Leaves). Call DrainSpools to deliver spooled
+// events.
+func (p *Publisher) UseSpools(spools map[string]*Spool) {
+	p.targets = sink.Leaves(p.sink)
+	p.spools = spools
+	p.spoolNotify = make(map[string]chan struct{}, len(spools))
+	for t := range spools {
+		p.spoolNotify[t] = make(chan struct{}, 1)
+	}
+}
+
+// spoolMsgs appends msgs[i], wrapped, for each i in idx to target's spool
+// and syncs it: a nil errs[i] lets the checkpoint move past the message.
+func (p *Publisher) spoolMsgs(target string, msgs []sink.Message, idx []int, errs []error) {
+	s := p.spools[target]
+	for _, i := range idx {
+		if err := s.Append(msgs[i]); err != nil {
+			errs[i] = errors.Join(errs[i], fmt.Errorf("spool event for %s: %w", target, err))
+		}
+	}
+	if err := s.Sync(); err != nil {
+		for _, i := range idx {
+			errs[i] = errors.Join(errs[i], fmt.Errorf("sync spool for %s: %w", target, err))
+		}
+	}
+	select {
+	case p.spoolNotify[target] <- struct{}{}:
+	default:
+	}
+}
+
+// DrainSpools publishes each target's spooled events oldest first, retrying
+// with backoff while the target is unavailable; a target that stays down
+// does not hold back the others. It returns when ctx is done.
+func (p *Publisher) DrainSpools(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range p.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.drain(ctx, t)
+		}()
+	}
+	wg.Wait()
+}
+
+// resend delivers spooled records to target in one batch. It returns how
+// many of them, oldest first, were delivered and the error that stopped the
+// rest; records after a failed one are sent again on the next try.
+func (p *Publisher) resend(target string, recs []spooled) (int, error) {
+	out := make([]sink.Message, len(recs))
+	logged := make([]sink.Message, len(recs))
+	errs := make([]error, len(recs))
+	for i, r := range recs {
+		m := r.msg
+		m.Targets = []string{target}
+		logged[i] = m
+		if !r.wrapped {
+			m.Payload, errs[i] = p.wrap(m.Payload)
+		}
+		out[i] = m
+	}
+	p.deliver(out, logged, errs)
+	for i, err := range errs {
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(recs), nil
+}
+
+func (p *Publisher) drain(ctx context.Context, target string) {
+	const maxBackoff = 30 * time.Second
+	backoff := time.Second
+	drained := 0
+	s := p.spools[target]
+
+	for {
+		recs, err := s.Peek(spoolDrainBatch)
+		if err == nil && len(recs) > 0 {
+			var n int
+			n, err = p.resend(target, recs)
+			if n > 0 {
+				drained += n
+				if err := s.Ack(n); err != nil {
+					slog.Error("spool ack", "sink", target, logging.Err(err))
+				}
+			}
+			if err == nil {
+				backoff = time.Second
+				continue
+			}
+		}
+		if errors.Is(err, errSpoolClosed) {
+			return
+		}
+
+		var wait <-chan time.Time
+		if err != nil {
+			slog.Warn("spool drain; retrying", "sink", target, logging.Err(err), "retry_in", backoff)
+			wait = time.After(backoff)
+			backoff = min(backoff*2, maxBackoff)
+		} else if drained > 0 {
+			slog.Info("Spool drained", "sink", target, "delivered", drained)
+			drained = 0
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-wait:
+		case <-p.spoolNotify[target]:
+			if wait != nil {
+				// New events arrived; keep waiting out the backoff.
+				select {
+				case <-ctx.Done():
+					return
+				case <-wait:
+				}
+			}
+		}
+	}
+}
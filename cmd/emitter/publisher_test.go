@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mysql_changelog_publisher/internal/envelope"
+	"mysql_changelog_publisher/internal/sink"
+	"mysql_changelog_publisher/internal/sink/sinktest"
+)
+
+// spoolingPublisher publishes to out with a spool per target under a
+// temporary directory, draining them until the test ends.
+func spoolingPublisher(t *testing.T, out sink.Sink) (*Publisher, map[string]*Spool) {
+	t.Helper()
+	spools, err := OpenSpools(t.TempDir(), sink.Leaves(out), 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPublisher(context.Background(), out, nil, nil)
+	p.UseSpools(spools)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.DrainSpools(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		for _, s := range spools {
+			s.Close()
+		}
+	})
+	return p, spools
+}
+
+func leadMsg(n int) sink.Message {
+	return sink.Message{
+		ID:      fmt.Sprintf("crm.leads:insert:%d", n),
+		Payload: []byte(fmt.Sprintf(`{"db":"crm","table":"leads","op":"create","row_key":%d}`, n)),
+	}
+}
+
+func publishOK(t *testing.T, p *Publisher, msgs ...sink.Message) {
+	t.Helper()
+	for i, err := range p.PublishBatch(msgs) {
+		if err != nil {
+			t.Fatalf("message %d neither delivered nor spooled: %v", i, err)
+		}
+	}
+}
+
+func TestSpoolRetriesOnlyFailedSinks(t *testing.T) {
+	a, b, c := sinktest.New("a"), sinktest.New("b"), sinktest.New("c")
+	p, spools := spoolingPublisher(t, sink.NewMulti(sink.All, a, b, c))
+
+	b.SetDown(true)
+	c.SetDown(true)
+	publishOK(t, p, leadMsg(1), leadMsg(2))
+	if !spools["b"].Pending() || !spools["c"].Pending() || spools["a"].Pending() {
+		t.Fatal("events not spooled for exactly the failed sinks")
+	}
+
+	// b comes back first and catches up while c is still down.
+	b.SetDown(false)
+	waitFor(t, func() bool { return !spools["b"].Pending() })
+	c.SetDown(false)
+	waitFor(t, func() bool { return !spools["c"].Pending() })
+
+	want := []string{"crm.leads:insert:1", "crm.leads:insert:2"}
+	for _, s := range []*sinktest.Fake{a, b, c} {
+		if got := s.Delivered(); !equalStrings(got, want) {
+			t.Errorf("sink %s got %v, want each event once: %v", s.Name(), got, want)
+		}
+	}
+}
+
+func TestHealthySinkKeepsReceivingWhileAnotherIsDown(t *testing.T) {
+	a, b := sinktest.New("a"), sinktest.New("b")
+	p, spools := spoolingPublisher(t, sink.NewMulti(sink.All, a, b))
+
+	b.SetDown(true)
+	publishOK(t, p, leadMsg(1))
+	// b's backlog doesn't hold back new events for a.
+	publishOK(t, p, leadMsg(2))
+	publishOK(t, p, leadMsg(3))
+	want := []string{"crm.leads:insert:1", "crm.leads:insert:2", "crm.leads:insert:3"}
+	if got := a.Delivered(); !equalStrings(got, want) {
+		t.Fatalf("healthy sink got %v while the other was down, want %v", got, want)
+	}
+	if spools["a"].Pending() {
+		t.Error("events spooled for the healthy sink")
+	}
+	if got := b.Delivered(); len(got) != 0 {
+		t.Fatalf("down sink got %v", got)
+	}
+
+	// b gets its backlog in order once it is back.
+	b.SetDown(false)
+	waitFor(t, func() bool { return !spools["b"].Pending() })
+	if got := b.Delivered(); !equalStrings(got, want) {
+		t.Errorf("recovered sink got %v, want %v", got, want)
+	}
+	if got := a.Delivered(); !equalStrings(got, want) {
+		t.Errorf("healthy sink got %v, want each event once: %v", got, want)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSpoolHoldsSealedPayloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	keyring := `{"active": "k1", "keys": {"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}`
+	if err := os.WriteFile(path, []byte(keyring), 0600); err != nil {
+		t.Fatal(err)
+	}
+	kr, err := envelope.LoadKeyring(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	a := sinktest.New("a")
+	a.SetDown(true)
+	spools, err := OpenSpools(dir, []string{"a"}, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spools["a"].Close()
+	p := NewPublisher(context.Background(), a, nil, envelope.NewWrapper(kr, nil))
+	p.UseSpools(spools)
+
+	msg := leadMsg(1)
+	msg.DB, msg.Table, msg.Op, msg.RowKey = "crm", "leads", "create", "1"
+	publishOK(t, p, msg)
+
+	segs, _ := filepath.Glob(filepath.Join(dir, "a", "*"+spoolSegmentExt))
+	for _, seg := range segs {
+		b, err := os.ReadFile(seg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, msg.Payload) {
+			t.Fatalf("%s holds the plaintext event", seg)
+		}
+	}
+
+	recs, err := spools["a"].Peek(10)
+	if err != nil || len(recs) != 1 || !recs[0].wrapped {
+		t.Fatalf("Peek = %+v, %v", recs, err)
+	}
+	got := recs[0].msg
+	if got.DB != "crm" || got.Table != "leads" || got.Op != "create" || got.RowKey != "1" {
+		t.Errorf("routing fields %+v not kept", got)
+	}
+	plain, err := kr.Open(got.Payload)
+	if err != nil || !bytes.Equal(plain, msg.Payload) {
+		t.Errorf("spooled payload opens to %s, %v", plain, err)
+	}
+}
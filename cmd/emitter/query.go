@@ -0,0 +1,132 @@
+package main
+
+// Originating SQL from Rows_query events (binlog_rows_query_log_events=ON)
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const defaultQueryMaxLen = 1024
+
+// stmtContext describes the statement that produced the rows events
+// currently being decoded.
+type stmtContext struct {
+	query  string
+	origin string
+	commit time.Time // when the source committed the transaction
+}
+
+// queryRedactor rewrites a statement before it is attached to events.
+type queryRedactor func(string) string
+
+type queryOptions struct {
+	Include   bool
+	MaxLen    int
+	Redactors []queryRedactor
+
+	literals bool // redactLiterals is among Redactors
+}
+
+var (
+	// originTag matches the comment writers add to mark their own changes,
+	// e.g. UPDATE leads /* cdc-origin=lead_sync */ SET ...
+	originTag = regexp.MustCompile(`/\*\s*cdc-origin\s*=\s*([\w.:-]+)\s*\*/`)
+
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"`)
+	sqlNumericLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+)
+
+// redactLiterals replaces quoted strings and numbers with '?', keeping the
+// shape of the statement but none of the values.
+func redactLiterals(q string) string {
+	q = sqlStringLiteral.ReplaceAllString(q, "?")
+	return sqlNumericLiteral.ReplaceAllString(q, "?")
+}
+
+func regexRedactor(re *regexp.Regexp) queryRedactor {
+	return func(q string) string {
+		return re.ReplaceAllString(q, "?")
+	}
+}
+
+func loadQueryOptions() (queryOptions, error) {
+	opts := queryOptions{MaxLen: defaultQueryMaxLen}
+
+	if v := os.Getenv("INCLUDE_QUERY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid INCLUDE_QUERY: %w", err)
+		}
+		opts.Include = b
+	}
+
+	if v := os.Getenv("QUERY_MAX_LEN"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("invalid QUERY_MAX_LEN: %q", v)
+		}
+		opts.MaxLen = n
+	}
+
+	switch mode := strings.ToLower(strings.TrimSpace(os.Getenv("QUERY_REDACT"))); mode {
+	case "", "none":
+	case "literals":
+		opts.Redactors = append(opts.Redactors, redactLiterals)
+		opts.literals = true
+	default:
+		return opts, fmt.Errorf("invalid QUERY_REDACT: %q (want none or literals)", mode)
+	}
+
+	if v := os.Getenv("QUERY_REDACT_REGEX"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid QUERY_REDACT_REGEX: %w", err)
+		}
+		opts.Redactors = append(opts.Redactors, regexRedactor(re))
+	}
+
+	return opts, nil
+}
+
+// withLiteralRedaction returns o with redactLiterals applied first, whatever
+// QUERY_REDACT says.
+func (o queryOptions) withLiteralRedaction() queryOptions {
+	if o.literals {
+		return o
+	}
+	o.Redactors = append([]queryRedactor{redactLiterals}, o.Redactors...)
+	o.literals = true
+	return o
+}
+
+// parseOrigin returns the cdc-origin tag embedded in a statement, if any.
+func parseOrigin(q string) string {
+	if m := originTag.FindStringSubmatch(q); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// format applies the redaction hooks and length limit to a statement. It
+// returns "" when query capture is disabled.
+func (o queryOptions) format(q string) string {
+	if !o.Include {
+		return ""
+	}
+	for _, redact := range o.Redactors {
+		q = redact(q)
+	}
+	if o.MaxLen > 0 && len(q) > o.MaxLen {
+		cut := o.MaxLen
+		for cut > 0 && !utf8.RuneStart(q[cut]) {
+			cut--
+		}
+		q = q[:cut] + "..."
+	}
+	return q
+}
@@ -0,0 +1,529 @@
+package main
+
+// On-disk spool for events that could not be published
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/sink"
+)
+
+const (
+	defaultSpoolMaxBytes     = int64(1 << 30)  // 1 GiB
+	defaultSpoolSegmentBytes = int64(64 << 20) // 64 MiB
+
+	spoolSegmentExt  = ".seg"
+	spoolCursorFile  = "spool.cursor"
+	spoolHeaderBytes = 8 // uint32 length + uint32 crc32
+)
+
+var errSpoolClosed = errors.New("spool closed")
+
+// Spool is a bounded FIFO of events stored in append-only segment files.
+// Records are appended to the newest segment and consumed from the oldest;
+// fully consumed segments are deleted. The read cursor is persisted so a
+// restart resumes where draining stopped.
+//
+// Record layout: uint32 length | uint32 crc32 | [targets] [route] event id |
+// '\n' | payload. The route, '\x01' followed by the event's db, table, op and
+// row key as JSON and '\n', marks a payload in wire form: encrypted and
+// signed as configured, so the spool holds no plaintext the sinks don't get.
+// Records without one, from older versions, hold plain event JSON. Each sink
+// target has its own spool (see OpenSpools); the optional targets, '\x00'
+// followed by comma-separated sink names and '\n', are only found in records
+// of the spool once shared by all targets.
+type Spool struct {
+	dir      string
+	maxBytes int64
+	segBytes int64
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	segments []uint64 // sequence numbers, oldest first; last one is written to
+	total    int64    // bytes on disk across all segments
+	w        *os.File
+	wSize    int64
+	r        *os.File // oldest segment, read with ReadAt
+	rSeq     uint64
+	rOff     int64
+	peekLens []int64 // sizes of the records returned by the last Peek
+	closed   bool
+}
+
+// OpenSpools opens a spool for each of targets, in subdirectories of dir.
+// Records left in dir itself by a spool shared by all targets are first
+// copied to the spools of the targets they were for and removed; a crash
+// while copying delivers them more than once, never less.
+func OpenSpools(dir string, targets []string, maxBytes, segBytes int64) (map[string]*Spool, error) {
+	spools := make(map[string]*Spool, len(targets))
+	closeAll := func() {
+		for _, s := range spools {
+			s.Close()
+		}
+	}
+	for _, t := range targets {
+		s, err := OpenSpool(filepath.Join(dir, url.PathEscape(t)), maxBytes, segBytes)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("%s: %w", t, err)
+		}
+		spools[t] = s
+	}
+	if err := migrateSharedSpool(dir, targets, spools); err != nil {
+		closeAll()
+		return nil, err
+	}
+	return spools, nil
+}
+
+func migrateSharedSpool(dir string, targets []string, spools map[string]*Spool) error {
+	segments, _, err := listSegments(dir)
+	if err != nil || len(segments) == 0 {
+		return err
+	}
+	seg := func(seq uint64) string { return filepath.Join(dir, segmentName(seq)) }
+	off := readCursor(dir, segments[0])
+
+	copied, dropped := 0, 0
+	for _, seq := range segments {
+		f, err := os.Open(seg(seq))
+		if err != nil {
+			return err
+		}
+		for {
+			body, err := readRecordAt(f, off)
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					slog.Warn("spool: skipping rest of segment", "segment", seg(seq), "offset", off, logging.Err(err))
+				}
+				break
+			}
+			off += int64(spoolHeaderBytes + len(body))
+			eventID, was, _, payload := parseRecord(body)
+			if len(was) == 0 {
+				was = targets
+			}
+			for _, t := range was {
+				s := spools[t]
+				if s == nil {
+					dropped++
+					continue
+				}
+				if err := s.append(eventID+"\n", payload); err != nil {
+					f.Close()
+					return err
+				}
+			}
+			copied++
+		}
+		f.Close()
+		off = 0
+	}
+
+	// Remove the shared spool only once every copy is on disk.
+	for _, s := range spools {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, seq := range segments {
+		if err := os.Remove(seg(seq)); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(filepath.Join(dir, spoolCursorFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	slog.Info("Moved the shared spool to per-sink spools", "spool", dir, "events", copied, "unknown_sinks", dropped)
+	return syncDir(dir)
+}
+
+// listSegments returns the sequence numbers of the segments in dir, oldest
+// first, and their total size.
+func listSegments(dir string) ([]uint64, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var segments []uint64
+	var total int64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, 0, err
+		}
+		segments = append(segments, seq)
+		total += info.Size()
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, total, nil
+}
+
+// readCursor returns the saved read offset into segment seq, or 0 when the
+// cursor points elsewhere.
+func readCursor(dir string, seq uint64) int64 {
+	b, err := os.ReadFile(filepath.Join(dir, spoolCursorFile))
+	if err != nil {
+		return 0
+	}
+	var cseq uint64
+	var off int64
+	if _, err := fmt.Sscanf(string(b), "%d %d", &cseq, &off); err != nil || cseq != seq {
+		return 0
+	}
+	return off
+}
+
+func OpenSpool(dir string, maxBytes, segBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes, segBytes: segBytes}
+	s.cond = sync.NewCond(&s.mu)
+
+	var err error
+	s.segments, s.total, err = listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Never append to a segment from a previous run: its tail may be torn.
+	next := uint64(1)
+	if n := len(s.segments); n > 0 {
+		next = s.segments[n-1] + 1
+	}
+	if err := s.openWriter(next); err != nil {
+		return nil, err
+	}
+
+	s.rSeq = s.segments[0]
+	s.rOff = readCursor(dir, s.rSeq)
+	if err := s.openReader(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, segmentName(seq))
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, spoolSegmentExt)
+}
+
+func (s *Spool) openWriter(seq uint64) error {
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(s.dir); err != nil {
+		f.Close()
+		return err
+	}
+	if s.w != nil {
+		if err := s.w.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+		s.w.Close()
+	}
+	s.w = f
+	s.wSize = 0
+	s.segments = append(s.segments, seq)
+	return nil
+}
+
+func (s *Spool) openReader() error {
+	f, err := os.Open(s.segmentPath(s.rSeq))
+	if err != nil {
+		return err
+	}
+	if s.r != nil {
+		s.r.Close()
+	}
+	s.r = f
+	return nil
+}
+
+// Pending reports whether there are records waiting to be drained.
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pendingLocked()
+}
+
+func (s *Spool) pendingLocked() bool {
+	return len(s.segments) > 1 || s.rOff < s.wSize
+}
+
+// spoolRoute holds the fields sinks route a message by, which can't be read
+// from a wrapped payload.
+type spoolRoute struct {
+	DB     string `json:"db"`
+	Table  string `json:"table"`
+	Op     string `json:"op"`
+	RowKey string `json:"row_key"`
+}
+
+// Append adds a record for msg, whose payload is in wire form, blocking
+// while the spool is full. Blocking here stalls the binlog reader, which is
+// the backpressure we want rather than dropping events.
+func (s *Spool) Append(msg sink.Message) error {
+	route, err := json.Marshal(spoolRoute{DB: msg.DB, Table: msg.Table, Op: msg.Op, RowKey: msg.RowKey})
+	if err != nil {
+		return err
+	}
+	return s.append("\x01"+string(route)+"\n"+msg.ID+"\n", msg.Payload)
+}
+
+func (s *Spool) append(head string, payload []byte) error {
+	rec := make([]byte, spoolHeaderBytes+len(head)+len(payload))
+	body := rec[spoolHeaderBytes:]
+	copy(body, head)
+	copy(body[len(head):], payload)
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(body))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !s.closed && s.total+int64(len(rec)) > s.maxBytes && s.pendingLocked() {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return errSpoolClosed
+	}
+
+	if s.wSize > 0 && s.wSize+int64(len(rec)) > s.segBytes {
+		if err := s.openWriter(s.segments[len(s.segments)-1] + 1); err != nil {
+			return err
+		}
+	}
+	if _, err := s.w.Write(rec); err != nil {
+		return err
+	}
+	s.wSize += int64(len(rec))
+	s.total += int64(len(rec))
+	s.cond.Broadcast()
+	return nil
+}
+
+// Sync flushes appended records to disk. Records must be synced before the
+// checkpoint moves past the events they hold.
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSpoolClosed
+	}
+	return s.w.Sync()
+}
+
+// spooled is a record returned by Peek; wrapped is false for records
+// holding plain event JSON.
+type spooled struct {
+	msg     sink.Message
+	wrapped bool
+}
+
+// Peek returns up to n of the oldest records, from one segment, without
+// consuming them. It returns none when the spool is empty.
+func (s *Spool) Peek(n int) ([]spooled, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errSpoolClosed
+	}
+
+	s.peekLens = s.peekLens[:0]
+	var recs []spooled
+	off := s.rOff
+	for len(recs) < n {
+		writing := s.rSeq == s.segments[len(s.segments)-1]
+		if writing && off >= s.wSize {
+			break
+		}
+
+		body, err := readRecordAt(s.r, off)
+		if err == nil {
+			size := int64(spoolHeaderBytes + len(body))
+			s.peekLens = append(s.peekLens, size)
+			off += size
+			id, _, route, data := parseRecord(body)
+			if route == nil {
+				recs = append(recs, spooled{msg: messageFor(id, data)})
+				continue
+			}
+			msg := sink.Message{ID: id, DB: route.DB, Table: route.Table, Op: route.Op, RowKey: route.RowKey, Payload: data}
+			recs = append(recs, spooled{msg: msg, wrapped: true})
+			continue
+		}
+		if len(recs) > 0 {
+			// The rest is for the next Peek, after these are acked.
+			break
+		}
+		if writing {
+			return nil, err
+		}
+		// End of a finished segment (or a torn tail from a crash): move on.
+		if !errors.Is(err, io.EOF) {
+			slog.Warn("spool: skipping rest of segment", "segment", s.segmentPath(s.rSeq), "offset", s.rOff, logging.Err(err))
+		}
+		if err := s.dropOldestLocked(); err != nil {
+			return nil, err
+		}
+		off = s.rOff
+	}
+	return recs, nil
+}
+
+// readRecordAt reads and checks the body of the record at off in f.
+func readRecordAt(f *os.File, off int64) ([]byte, error) {
+	var hdr [spoolHeaderBytes]byte
+	if _, err := f.ReadAt(hdr[:], off); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[0:4])
+	body := make([]byte, n)
+	if _, err := f.ReadAt(body, off+spoolHeaderBytes); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(hdr[4:8]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return body, nil
+}
+
+// parseRecord splits a record body into its parts; route is nil for plain
+// event JSON.
+func parseRecord(body []byte) (eventID string, targets []string, route *spoolRoute, payload []byte) {
+	rest := string(body)
+	if list, ok := strings.CutPrefix(rest, "\x00"); ok {
+		var names string
+		names, rest, _ = strings.Cut(list, "\n")
+		targets = strings.Split(names, ",")
+	}
+	if line, ok := strings.CutPrefix(rest, "\x01"); ok {
+		var js string
+		js, rest, _ = strings.Cut(line, "\n")
+		route = new(spoolRoute)
+		_ = json.Unmarshal([]byte(js), route)
+	}
+	eventID, data, _ := strings.Cut(rest, "\n")
+	return eventID, targets, route, []byte(data)
+}
+
+// Ack consumes the first n records returned by the last Peek, saving the
+// cursor once for all of them.
+func (s *Spool) Ack(n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, size := range s.peekLens[:n] {
+		s.rOff += size
+	}
+	s.peekLens = s.peekLens[:0]
+
+	// Fully drained: start a fresh segment so the old one can be removed.
+	if !s.pendingLocked() && s.wSize > 0 {
+		if err := s.openWriter(s.segments[len(s.segments)-1] + 1); err != nil {
+			return err
+		}
+		return s.dropOldestLocked()
+	}
+	return s.writeCursorLocked()
+}
+
+// writeCursorLocked replaces the cursor file atomically and durably, so a
+// crash never resumes before a record that was already delivered.
+func (s *Spool) writeCursorLocked() error {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d %d", s.rSeq, s.rOff)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// syncDir makes file creations, renames and removals in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (s *Spool) dropOldestLocked() error {
+	path := s.segmentPath(s.rSeq)
+	if info, err := os.Stat(path); err == nil {
+		s.total -= info.Size()
+	}
+	s.r.Close()
+	s.r = nil
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	s.segments = s.segments[1:]
+	s.rSeq = s.segments[0]
+	s.rOff = 0
+	s.cond.Broadcast()
+	if err := s.openReader(); err != nil {
+		return err
+	}
+	return s.writeCursorLocked()
+}
+
+// Close wakes any blocked Append and releases the segment files. It is safe
+// to call more than once.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	if s.r != nil {
+		s.r.Close()
+	}
+	return s.w.Close()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mysql_changelog_publisher/internal/sink"
+)
+
+// drainAll peeks and acks every record in s, returning their ids and
+// payloads.
+func drainAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var got []string
+	for {
+		recs, err := s.Peek(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recs) == 0 {
+			return got
+		}
+		for _, r := range recs {
+			got = append(got, r.msg.ID+" "+string(r.msg.Payload))
+		}
+		if err := s.Ack(len(recs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenSpoolsMovesSharedSpool(t *testing.T) {
+	dir := t.TempDir()
+	// A spool shared by all sinks, as written before spools were per sink.
+	var seg []byte
+	for _, r := range []struct{ head, payload string }{
+		{"e1\n", "one"},
+		{"\x00b\ne2\n", "two"},
+		{"\x00a,gone\ne3\n", "three"},
+	} {
+		body := r.head + r.payload
+		var hdr [spoolHeaderBytes]byte
+		binary.LittleEndian.PutUint32(hdr[0:4], uint32(len(body)))
+		binary.LittleEndian.PutUint32(hdr[4:8], crc32.ChecksumIEEE([]byte(body)))
+		seg = append(append(seg, hdr[:]...), body...)
+	}
+	if err := os.WriteFile(filepath.Join(dir, segmentName(1)), seg, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spools, err := OpenSpools(dir, []string{"a", "b"}, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, s := range spools {
+			s.Close()
+		}
+	}()
+	if got, want := strings.Join(drainAll(t, spools["a"]), ","), "e1 one,e3 three"; got != want {
+		t.Errorf("spool a holds %q, want %q", got, want)
+	}
+	if got, want := strings.Join(drainAll(t, spools["b"]), ","), "e1 one,e2 two"; got != want {
+		t.Errorf("spool b holds %q, want %q", got, want)
+	}
+	if old, _ := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt)); len(old) != 0 {
+		t.Errorf("shared segments left behind: %v", old)
+	}
+}
+
+func appendN(t *testing.T, s *Spool, from, to int) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		if err := s.Append(sink.Message{ID: fmt.Sprintf("e%d", i), Payload: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolAckBatchResumesAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, s, 1, 5)
+	recs, err := s.Peek(3)
+	if err != nil || len(recs) != 3 {
+		t.Fatalf("Peek(3) = %d records, %v", len(recs), err)
+	}
+	// Only the first two made it; the third is read again.
+	if err := s.Ack(2); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, want := strings.Join(drainAll(t, s), ","), "e3 3,e4 4,e5 5"; got != want {
+		t.Errorf("after reopen read %q, want %q", got, want)
+	}
+	if s.Pending() {
+		t.Error("drained spool still pending")
+	}
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	segs, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segs
+}
+
+func TestSpoolRollsAndDropsSegments(t *testing.T) {
+	dir := t.TempDir()
+	// Small segments: every record starts a new one.
+	s, err := OpenSpool(dir, 1<<20, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	appendN(t, s, 1, 4)
+	if n := len(segments(t, dir)); n != 4 {
+		t.Fatalf("%d segments for 4 records, want 4", n)
+	}
+
+	// Peek stays within one segment.
+	recs, err := s.Peek(10)
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("Peek(10) = %d records, %v; want the first segment's one", len(recs), err)
+	}
+	if got, want := strings.Join(drainAll(t, s), ","), "e1 1,e2 2,e3 3,e4 4"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	// Only the fresh segment written to next is left.
+	if segs := segments(t, dir); len(segs) != 1 {
+		t.Errorf("segments after drain: %v", segs)
+	}
+	if s.total != 0 {
+		t.Errorf("spool size after drain = %d", s.total)
+	}
+}
+
+func TestSpoolSkipsDamagedRecords(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, s, 1, 2)
+	s.Close()
+
+	// Corrupt e2, then add a torn record after it as a crash mid-write
+	// would leave.
+	seg := segments(t, dir)[0]
+	b, err := os.ReadFile(seg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[len(b)-1] ^= 0xff
+	b = append(b, 0xff, 0, 0, 0, 1, 2, 3, 4, 'x')
+	if err := os.WriteFile(seg, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendN(t, s, 3, 3)
+	if got, want := strings.Join(drainAll(t, s), ","), "e1 1,e3 3"; got != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+	s.Close()
+
+	// A torn tail on its own is skipped the same way after a restart.
+	s, err = OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	appendN(t, s, 4, 4)
+	seg = segments(t, dir)[len(segments(t, dir))-1]
+	f, err := os.OpenFile(seg, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{9, 0})
+	f.Close()
+	s.Close()
+
+	s, err = OpenSpool(dir, 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	appendN(t, s, 5, 5)
+	if got, want := strings.Join(drainAll(t, s), ","), "e4 4,e5 5"; got != want {
+		t.Errorf("after torn tail read %q, want %q", got, want)
+	}
+}
+
+func TestSpoolAppendBlocksWhenFull(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 1<<20, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	appendN(t, s, 1, 1)
+	s.maxBytes = 2 * s.total // room for two records
+	appendN(t, s, 2, 2)
+
+	appended := make(chan error, 1)
+	go func() {
+		appended <- s.Append(sink.Message{ID: "e3", Payload: []byte("3")})
+	}()
+	select {
+	case err := <-appended:
+		t.Fatalf("Append into a full spool returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Draining frees the space.
+	recs, err := s.Peek(2)
+	if err != nil || len(recs) != 2 {
+		t.Fatalf("Peek(2) = %d records, %v", len(recs), err)
+	}
+	if err := s.Ack(2); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-appended:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Append still blocked after the spool was drained")
+	}
+
+	// Close wakes a blocked Append.
+	appendN(t, s, 4, 4)
+	go func() {
+		appended <- s.Append(sink.Message{ID: "e5", Payload: []byte("5")})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	if err := <-appended; !errors.Is(err, errSpoolClosed) {
+		t.Errorf("blocked Append after Close = %v, want errSpoolClosed", err)
+	}
+}
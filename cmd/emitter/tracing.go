@@ -0,0 +1,40 @@
+package main
+
+// Emit spans and commit-to-emit latency
+import (
+	"context"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceEmit stamps e with its emit time and the trace context of an emit
+// span covering commit to emit, and records the commit-to-emit latency.
+func traceEmit(e *event.RowEvent) {
+	now := time.Now()
+	e.EmitTime = now.UTC().Format(time.RFC3339Nano)
+
+	start := now
+	if commit, err := time.Parse(time.RFC3339Nano, e.CommitTime); err == nil {
+		metrics.commitToEmit.Observe(max(0, now.Sub(commit)).Seconds())
+		if commit.Before(now) {
+			start = commit
+		}
+	}
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "emit "+e.DB+"."+e.Table,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			attribute.String("db.namespace", e.DB),
+			attribute.String("db.collection.name", e.Table),
+			attribute.String("cdc.op", e.Op),
+			attribute.String("cdc.row_key", rowKeyString(e.RowKey)),
+		))
+	e.TraceParent = tracing.TraceParent(ctx)
+	span.End(trace.WithTimestamp(now))
+}
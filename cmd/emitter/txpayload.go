@@ -0,0 +1,84 @@
+package main
+
+// Decoding the events inside compressed transaction payloads
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-mysql-org/go-mysql/replication"
+	"github.com/klauspost/compress/zstd"
+)
+
+// newBinlogParser returns a parser with the same decoding options as the
+// replication stream: DECIMAL as decimal.Decimal, DATETIME as time.Time.
+func newBinlogParser() *replication.BinlogParser {
+	p := replication.NewBinlogParser()
+	p.SetUseDecimal(true)
+	p.SetParseTime(true)
+	return p
+}
+
+// newPayloadParser returns a parser for the events embedded in transaction
+// payloads written under fde. Embedded events carry no checksums.
+func newPayloadParser(fde *replication.FormatDescriptionEvent) (*replication.BinlogParser, error) {
+	body := binary.LittleEndian.AppendUint16(nil, fde.Version)
+	version := make([]byte, 50)
+	copy(version, fde.ServerVersion)
+	body = append(body, version...)
+	body = binary.LittleEndian.AppendUint32(body, fde.CreateTimestamp)
+	body = append(body, fde.EventHeaderLength)
+	body = append(body, fde.EventTypeHeaderLengths...)
+	body = append(body, replication.BINLOG_CHECKSUM_ALG_OFF, 0, 0, 0, 0)
+
+	header := binary.LittleEndian.AppendUint32(nil, fde.CreateTimestamp)
+	header = append(header, byte(replication.FORMAT_DESCRIPTION_EVENT))
+	header = binary.LittleEndian.AppendUint32(header, 0) // server id
+	header = binary.LittleEndian.AppendUint32(header, uint32(replication.EventHeaderSize+len(body)))
+	header = binary.LittleEndian.AppendUint32(header, 0) // log pos
+	header = binary.LittleEndian.AppendUint16(header, 0) // flags
+
+	p := newBinlogParser()
+	if _, err := p.Parse(append(header, body...)); err != nil {
+		return nil, fmt.Errorf("format description: %w", err)
+	}
+	return p, nil
+}
+
+// payloadEvents decompresses e and parses its events with p. go-mysql
+// already fills e.Events, but with a parser of its own that ignores the
+// stream's decoding options, so those are not used.
+func payloadEvents(p *replication.BinlogParser, e *replication.TransactionPayloadEvent) ([]*replication.BinlogEvent, error) {
+	if p == nil {
+		return nil, fmt.Errorf("no format description event before the transaction payload")
+	}
+	if e.CompressionType != replication.ZSTD {
+		return nil, fmt.Errorf("unsupported payload compression type %d", e.CompressionType)
+	}
+	dec, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	data, err := dec.DecodeAll(e.Payload, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decompress payload: %w", err)
+	}
+
+	var events []*replication.BinlogEvent
+	for len(data) > 0 {
+		if len(data) < replication.EventHeaderSize {
+			return nil, fmt.Errorf("truncated event header in payload")
+		}
+		size := binary.LittleEndian.Uint32(data[9:13])
+		if size < uint32(replication.EventHeaderSize) || int(size) > len(data) {
+			return nil, fmt.Errorf("event size %d exceeds the %d payload bytes left", size, len(data))
+		}
+		ev, err := p.Parse(data[:size])
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+		data = data[size:]
+	}
+	return events, nil
+}
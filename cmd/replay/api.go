@@ -0,0 +1,60 @@
+package main
+
+// Replaying events to a subscriber's API_URL
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/subscriber"
+	"mysql_changelog_publisher/internal/tracing"
+)
+
+// apiTarget posts the events a subscriber's filters match to its API_URL,
+// one call per event, as cmd/subscribers does without debouncing.
+type apiTarget struct {
+	url    string
+	filter *subscriber.Filter
+	client *http.Client
+}
+
+func newAPITarget(cfg *subscriber.Config, url string) *apiTarget {
+	slog.Info("Replaying to subscriber API", "subscriber", cfg.Name, "url", url)
+	return &apiTarget{url: url, filter: subscriber.NewFilter(cfg), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (t *apiTarget) send(ctx context.Context, r *record) error {
+	ev := r.Event
+	if ev.Op == event.OpHeartbeat || !t.filter.Matches(ev) {
+		return nil
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(tracing.Extract(ctx, ev.TraceParent), req.Header)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("api returned %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
+
+func (t *apiTarget) Close() error { return nil }
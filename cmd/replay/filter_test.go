@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+)
+
+func rec(db, table, op, id string, at time.Time) *record {
+	return &record{At: at, ID: id, Event: &event.RowEvent{DB: db, Table: table, Op: op}}
+}
+
+func TestFilterMatch(t *testing.T) {
+	t0 := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	create := rec("crm", "leads", "create", "crm.leads:insert:1", t0)
+	update := rec("crm", "leads", "update", "crm.leads:update:1", t0.Add(time.Hour))
+	other := rec("billing", "invoices", "delete", "billing.invoices:delete:9", t0)
+	untimed := rec("crm", "leads", "update", "crm.leads:update:2", time.Time{})
+	heartbeat := rec("", "", event.OpHeartbeat, "heartbeat", t0)
+
+	for _, tc := range []struct {
+		name                               string
+		since, until, dbs, tables, ops, id string
+		r                                  *record
+		want                               bool
+	}{
+		{name: "no filters", r: create, want: true},
+		{name: "heartbeat skipped by default", r: heartbeat, want: false},
+		{name: "heartbeat when asked for", ops: "heartbeat", r: heartbeat, want: true},
+		{name: "op insert matches create", ops: "insert", r: create, want: true},
+		{name: "op create matches create", ops: "CREATE", r: create, want: true},
+		{name: "op filter excludes others", ops: "insert", r: update, want: false},
+		{name: "db", dbs: "crm", r: other, want: false},
+		{name: "table by name", tables: "leads", r: create, want: true},
+		{name: "table by db.table", tables: "billing.invoices", r: other, want: true},
+		{name: "table of another db", tables: "billing.leads", r: create, want: false},
+		{name: "since is inclusive", since: "2025-03-01T10:00:00Z", r: create, want: true},
+		{name: "until is exclusive", until: "2025-03-01T11:00:00Z", r: update, want: false},
+		{name: "time range needs a time", since: "2025-01-01", r: untimed, want: false},
+		{name: "id wildcard", id: "crm.leads:insert:*", r: create, want: true},
+		{name: "id wildcard miss", id: "crm.leads:insert:*", r: update, want: false},
+		{name: "any id pattern", id: "nope, *:delete:*", r: other, want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newFilter(tc.since, tc.until, tc.dbs, tc.tables, tc.ops, tc.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := f.match(tc.r); got != tc.want {
+				t.Errorf("match = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewFilterErrors(t *testing.T) {
+	if _, err := newFilter("yesterday", "", "", "", "", ""); err == nil {
+		t.Error("bad -since accepted")
+	}
+	if _, err := newFilter("", "", "", "", "", "crm.[leads"); err == nil {
+		t.Error("bad -event-id pattern accepted")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+// Reading recorded events from message logs and JSONL archives
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/subscriber"
+)
+
+const maxLineBytes = 64 << 20
+
+// record is one recorded event.
+type record struct {
+	At    time.Time // when it was published; zero when unknown
+	ID    string    // db.table:kind:row_key, as the emitter builds it
+	Raw   string    // payload as recorded
+	JSON  string    // Raw decrypted and verified
+	Event *event.RowEvent
+}
+
+// readRecords calls fn for every event in paths, in order. Lines are either
+// MESSAGE_LOG_FILE lines ("<time> event_id=<id> payload=<json>") or bare
+// payloads as written by the file sink; files ending in .gz are
+// decompressed. Lines that can't be opened or decoded are logged and
+// skipped.
+func readRecords(ctx context.Context, paths []string, opener *subscriber.PayloadOpener, fn func(*record) error) (skipped int, err error) {
+	for _, path := range paths {
+		n, err := readFile(ctx, path, opener, fn)
+		skipped += n
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+func readFile(ctx context.Context, path string, opener *subscriber.PayloadOpener, fn func(*record) error) (skipped int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), maxLineBytes)
+	for lineNo := 1; sc.Scan(); lineNo++ {
+		if err := ctx.Err(); err != nil {
+			return skipped, err
+		}
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		rec, err := parseLine(line, opener)
+		if err != nil {
+			slog.Warn("skipping line", "file", path, "line", lineNo, logging.Err(err))
+			skipped++
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return skipped, err
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return skipped, fmt.Errorf("%s: %w", path, err)
+	}
+	return skipped, nil
+}
+
+func parseLine(line string, opener *subscriber.PayloadOpener) (*record, error) {
+	rec := &record{Raw: line}
+	if ts, rest, ok := strings.Cut(line, " event_id="); ok {
+		if at, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			id, payload, ok := strings.Cut(rest, " payload=")
+			if !ok {
+				return nil, fmt.Errorf("message log line without payload")
+			}
+			rec.At, rec.ID, rec.Raw = at, id, payload
+		}
+	}
+
+	plain, err := opener.Open(rec.Raw)
+	if err != nil {
+		return nil, err
+	}
+	var ev event.RowEvent
+	dec := json.NewDecoder(strings.NewReader(plain))
+	dec.UseNumber()
+	if err := dec.Decode(&ev); err != nil {
+		return nil, fmt.Errorf("json decode: %w", err)
+	}
+	rec.JSON, rec.Event = plain, &ev
+	if rec.ID == "" {
+		rec.ID = fmt.Sprintf("%s.%s:%s:%v", ev.DB, ev.Table, eventKind(ev.Op), ev.RowKey)
+	}
+	if rec.At.IsZero() {
+		rec.At = eventTime(&ev)
+	}
+	return rec, nil
+}
+
+// eventKind maps an event's op to the kind the emitter puts in event ids:
+// creates are "insert".
+func eventKind(op string) string {
+	op = strings.ToLower(op)
+	if op == "create" {
+		return "insert"
+	}
+	return op
+}
+
+// eventTime is when the emitter published ev: emit_time, else commit_time,
+// else the IST timestamp.
+func eventTime(ev *event.RowEvent) time.Time {
+	for _, s := range []string{ev.EmitTime, ev.CommitTime} {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			return t
+		}
+	}
+	if ist, err := time.LoadLocation("Asia/Kolkata"); err == nil {
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", ev.Timestamp, ist); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mysql_changelog_publisher/internal/envelope"
+	"mysql_changelog_publisher/internal/subscriber"
+)
+
+func TestParseLineWrapped(t *testing.T) {
+	dir := t.TempDir()
+	env := map[string]string{
+		"EVENT_KEYRING_FILE":     filepath.Join(dir, "keyring.json"),
+		"EVENT_SIGNING_ALG":      envelope.AlgHMACSHA256,
+		"EVENT_SIGNING_KEY_FILE": filepath.Join(dir, "sign.key"),
+		"EVENT_VERIFY_KEY_FILE":  filepath.Join(dir, "sign.key"),
+		"REQUIRE_SIGNED_EVENTS":  "true",
+	}
+	keyring := `{"active": "k1", "keys": {"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}`
+	if err := os.WriteFile(env["EVENT_KEYRING_FILE"], []byte(keyring), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(env["EVENT_SIGNING_KEY_FILE"], []byte("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := envelope.WrapperFromEnv(func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	const plain = `{"op":"create","db":"crm","table":"leads","row_key":1}`
+	payload, err := w.Wrap([]byte(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, ok := envelope.ParseSigned(payload)
+	if !ok || !envelope.IsSealed(signed.Payload) {
+		t.Fatalf("payload is not a signed envelope: %s", payload)
+	}
+
+	// A subscriber with the same keys reads back the original event.
+	opener, err := subscriber.NewPayloadOpener(subscriber.LoadConfigFromMap(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := parseLine(string(payload), opener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.JSON != plain || rec.ID != "crm.leads:insert:1" {
+		t.Errorf("read back %s as %s", rec.JSON, rec.ID)
+	}
+}
+
+func plainOpener(t *testing.T) *subscriber.PayloadOpener {
+	t.Helper()
+	o, err := subscriber.NewPayloadOpener(&subscriber.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestParseLine(t *testing.T) {
+	at := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name, line string
+		id         string
+		at         time.Time
+		err        string
+	}{{
+		name: "message log",
+		line: `2025-03-01T10:00:00Z event_id=crm.leads:update:7 payload={"op":"update","db":"crm","table":"leads","row_key":7}`,
+		id:   "crm.leads:update:7",
+		at:   at,
+	}, {
+		name: "jsonl create is an insert id",
+		line: `{"op":"create","db":"crm","table":"leads","row_key":1,"emit_time":"2025-03-01T10:00:00Z"}`,
+		id:   "crm.leads:insert:1",
+		at:   at,
+	}, {
+		name: "jsonl string row key",
+		line: `{"op":"DELETE","db":"crm","table":"notes","row_key":"a-1"}`,
+		id:   "crm.notes:delete:a-1",
+	}, {
+		name: "commit time when no emit time",
+		line: `{"op":"update","db":"crm","table":"leads","row_key":2,"commit_time":"2025-03-01T10:00:00Z"}`,
+		id:   "crm.leads:update:2",
+		at:   at,
+	}, {
+		name: "IST timestamp last",
+		line: `{"op":"update","db":"crm","table":"leads","row_key":3,"timestamp":"2025-03-01 15:30:00"}`,
+		id:   "crm.leads:update:3",
+		at:   at,
+	}, {
+		name: "large row key keeps its digits",
+		line: `{"op":"update","db":"crm","table":"leads","row_key":9007199254740993}`,
+		id:   "crm.leads:update:9007199254740993",
+	}, {
+		name: "message log without payload",
+		line: `2025-03-01T10:00:00Z event_id=crm.leads:update:7`,
+		err:  "without payload",
+	}, {
+		name: "not json",
+		line: `hello`,
+		err:  "json decode",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec, err := parseLine(tc.line, plainOpener(t))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("error = %v, want %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.ID != tc.id {
+				t.Errorf("ID = %q, want %q", rec.ID, tc.id)
+			}
+			if !rec.At.Equal(tc.at) {
+				t.Errorf("At = %v, want %v", rec.At, tc.at)
+			}
+		})
+	}
+}
+
+func TestReadRecordsSkipsBadLines(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "events.jsonl")
+	if err := os.WriteFile(plain, []byte(`{"op":"create","db":"crm","table":"leads","row_key":1}
+not json
+
+{"op":"update","db":"crm","table":"leads","row_key":1}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gz := filepath.Join(dir, "events.jsonl.gz")
+	f, err := os.Create(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(f)
+	zw.Write([]byte(`{"op":"delete","db":"crm","table":"leads","row_key":1}` + "\n"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var ids []string
+	skipped, err := readRecords(context.Background(), []string{plain, gz}, plainOpener(t), func(r *record) error {
+		ids = append(ids, r.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "crm.leads:insert:1 crm.leads:update:1 crm.leads:delete:1"
+	if got := strings.Join(ids, " "); got != want || skipped != 1 {
+		t.Errorf("read %s, skipped %d; want %s, skipped 1", got, skipped, want)
+	}
+}
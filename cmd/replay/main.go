@@ -0,0 +1,248 @@
+package main
+
+// Replays recorded events to Redis, stdout or a subscriber
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"mysql_changelog_publisher/internal/envelope"
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/redisconn"
+	"mysql_changelog_publisher/internal/sink"
+	"mysql_changelog_publisher/internal/subscriber"
+
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: replay [flags] FILE...
+
+Replays events recorded in MESSAGE_LOG_FILE logs or JSONL archives (the file
+sink's output), plain or gzipped, in the order given. Redis connection
+settings (REDIS_ADDR, REDIS_URL, ...) and EVENT_KEYRING_FILE /
+EVENT_VERIFY_KEY_FILE, needed to filter encrypted archives, come from the
+environment or .env. Events replayed to Redis are sealed and signed as the
+emitter does when EVENT_KEYRING_FILE / EVENT_SIGNING_KEY_FILE are set.
+
+Flags:
+`
+
+type target interface {
+	send(ctx context.Context, r *record) error
+	Close() error
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("replay failed", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	_ = godotenv.Load()
+	if err := logging.Setup(os.Getenv); err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	since := fs.String("since", "", "replay events published at or after this time (RFC 3339 or local \"2006-01-02 15:04:05\")")
+	until := fs.String("until", "", "replay events published before this time")
+	dbs := fs.String("db", "", "comma-separated databases")
+	tables := fs.String("table", "", "comma-separated tables (table or db.table)")
+	ops := fs.String("op", "", "comma-separated ops (insert or create, update, delete; heartbeat to include heartbeats)")
+	ids := fs.String("event-id", "", "comma-separated event ids (db.table:op:row_key), * and ? match any characters")
+	rate := fs.String("rate", rateMax, "max, original (recorded pacing) or events per second")
+	to := fs.String("to", "redis", "redis or stdout; ignored with -subscriber")
+	channel := fs.String("channel", "", "Redis channel (default REDIS_CHANNEL, else "+subscriber.DefaultRedisChannel+")")
+	subEnv := fs.String("subscriber", "", "feed events to the subscriber configured by this env file instead, calling its API_URL if set")
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := newFilter(*since, *until, *dbs, *tables, *ops, *ids)
+	if err != nil {
+		return err
+	}
+	pace, err := newPacer(*rate)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Filtering needs the plaintext; the subscriber's keys decide what it can
+	// read.
+	cfg := subscriber.LoadConfigFromLookup(os.LookupEnv)
+	var out target
+	switch {
+	case *subEnv != "":
+		vals, err := subscriber.LoadEnvFiles([]string{*subEnv})
+		if err != nil {
+			return err
+		}
+		cfg = subscriber.LoadConfigFromMap(vals)
+		if strings.TrimSpace(cfg.Name) == "" {
+			cfg.Name = strings.TrimSuffix(filepath.Base(*subEnv), filepath.Ext(*subEnv))
+		}
+		if url := strings.TrimSpace(vals["API_URL"]); url != "" {
+			out = newAPITarget(cfg, url)
+		} else {
+			out = startSubscriber(ctx, cfg)
+		}
+	case *to == "stdout":
+		out = stdoutTarget{}
+	case *to == "redis":
+		if *channel == "" {
+			*channel = envDefault("REDIS_CHANNEL", subscriber.DefaultRedisChannel)
+		}
+		out, err = openRedis(ctx, *channel)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid -to %q (want redis or stdout)", *to)
+	}
+	opener, err := subscriber.NewPayloadOpener(cfg)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	var read, sent int
+	skipped, err := readRecords(ctx, fs.Args(), opener, func(r *record) error {
+		read++
+		if !f.match(r) {
+			return nil
+		}
+		if err := pace.wait(ctx, r.At); err != nil {
+			return err
+		}
+		if err := out.send(ctx, r); err != nil {
+			return fmt.Errorf("replay %s: %w", r.ID, err)
+		}
+		sent++
+		return nil
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	slog.Info("replay done", "read", read, "replayed", sent, "skipped", skipped)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+func envDefault(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+type stdoutTarget struct{}
+
+func (stdoutTarget) send(ctx context.Context, r *record) error {
+	_, err := fmt.Println(r.Raw)
+	return err
+}
+
+func (stdoutTarget) Close() error { return nil }
+
+// redisTarget republishes the recorded events, sealed and signed with the
+// emitter's settings from the environment.
+type redisTarget struct {
+	*sink.Redis
+	wrapper *envelope.Wrapper
+}
+
+func openRedis(ctx context.Context, channel string) (*redisTarget, error) {
+	w, err := envelope.WrapperFromEnv(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := redisconn.FromEnv(os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	client, err := opts.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	r := &redisTarget{sink.NewRedis("redis", client, channel), w}
+	if err := r.Ping(ctx); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("redis %s: %w", opts, err)
+	}
+	slog.Info("Replaying to Redis", "redis", opts.String(), "channel", channel)
+	return r, nil
+}
+
+func (r *redisTarget) send(ctx context.Context, rec *record) error {
+	payload, err := r.wrapper.Wrap([]byte(rec.JSON))
+	if err != nil {
+		return err
+	}
+	ev := rec.Event
+	msg := sink.Message{
+		ID:      rec.ID,
+		DB:      ev.DB,
+		Table:   ev.Table,
+		Op:      ev.Op,
+		RowKey:  fmt.Sprint(ev.RowKey),
+		Payload: payload,
+	}
+	return r.Publish(ctx, []sink.Message{msg})[0]
+}
+
+// subscriberTarget runs a subscriber without an API_URL in process, fed from
+// a channel instead of its broker; matching events are printed as the
+// console subscriber does.
+type subscriberTarget struct {
+	ch   chan string
+	done chan error
+}
+
+func startSubscriber(ctx context.Context, cfg *subscriber.Config) *subscriberTarget {
+	t := &subscriberTarget{ch: make(chan string), done: make(chan error, 1)}
+	cfg.Input = &subscriber.Feed{Name: "replay", C: t.ch}
+	go func() { t.done <- subscriber.Run(ctx, cfg) }()
+	return t
+}
+
+func (t *subscriberTarget) send(ctx context.Context, r *record) error {
+	select {
+	case t.ch <- r.Raw:
+		return nil
+	case err := <-t.done:
+		t.done <- err
+		return fmt.Errorf("subscriber exited: %w", err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close waits for the subscriber to handle every event sent.
+func (t *subscriberTarget) Close() error {
+	close(t.ch)
+	err := <-t.done
+	if errors.Is(err, subscriber.ErrSourceClosed) {
+		return nil
+	}
+	return err
+}
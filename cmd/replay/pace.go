@@ -0,0 +1,71 @@
+package main
+
+// Replay rate control
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	rateMax      = "max"
+	rateOriginal = "original"
+)
+
+// pacer delays sends: not at all (max), keeping the recorded gaps between
+// events (original), or to a fixed number of events per second.
+type pacer struct {
+	original bool
+	perSec   float64
+
+	start   time.Time // when the first event was sent
+	firstAt time.Time // recorded time of the first timed event (original)
+	sent    int
+}
+
+func newPacer(rate string) (*pacer, error) {
+	switch r := strings.ToLower(strings.TrimSpace(rate)); r {
+	case "", rateMax:
+		return &pacer{}, nil
+	case rateOriginal:
+		return &pacer{original: true}, nil
+	default:
+		n, err := strconv.ParseFloat(r, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid -rate %q (want max, original or events per second)", rate)
+		}
+		return &pacer{perSec: n}, nil
+	}
+}
+
+// wait blocks until the event recorded at `at` is due.
+func (p *pacer) wait(ctx context.Context, at time.Time) error {
+	now := time.Now()
+	if p.start.IsZero() {
+		p.start = now
+	}
+	var due time.Time
+	switch {
+	case p.perSec > 0:
+		due = p.start.Add(time.Duration(float64(p.sent) / p.perSec * float64(time.Second)))
+	case p.original && !at.IsZero():
+		if p.firstAt.IsZero() {
+			p.firstAt = at
+		}
+		due = p.start.Add(at.Sub(p.firstAt))
+	}
+	p.sent++
+	if !due.After(now) {
+		return ctx.Err()
+	}
+	t := time.NewTimer(due.Sub(now))
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewPacer(t *testing.T) {
+	for _, tc := range []struct {
+		rate     string
+		original bool
+		perSec   float64
+		err      bool
+	}{
+		{rate: ""},
+		{rate: "max"},
+		{rate: " Original ", original: true},
+		{rate: "2.5", perSec: 2.5},
+		{rate: "0", err: true},
+		{rate: "-1", err: true},
+		{rate: "fast", err: true},
+	} {
+		p, err := newPacer(tc.rate)
+		if tc.err {
+			if err == nil {
+				t.Errorf("newPacer(%q) accepted", tc.rate)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newPacer(%q): %v", tc.rate, err)
+			continue
+		}
+		if p.original != tc.original || p.perSec != tc.perSec {
+			t.Errorf("newPacer(%q) = %+v", tc.rate, p)
+		}
+	}
+}
+
+// elapsed returns how long sending events recorded at ats takes with p.
+func elapsed(t *testing.T, p *pacer, ats ...time.Time) time.Duration {
+	t.Helper()
+	start := time.Now()
+	for _, at := range ats {
+		if err := p.wait(context.Background(), at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return time.Since(start)
+}
+
+func TestPacerWait(t *testing.T) {
+	t0 := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	gaps := []time.Time{t0, t0.Add(100 * time.Millisecond), {}, t0.Add(200 * time.Millisecond)}
+
+	if d := elapsed(t, &pacer{}, gaps...); d > 50*time.Millisecond {
+		t.Errorf("max took %v", d)
+	}
+	// Recorded gaps are kept; an untimed event goes out right away.
+	if d := elapsed(t, &pacer{original: true}, gaps...); d < 200*time.Millisecond || d > time.Second {
+		t.Errorf("original took %v, want about 200ms", d)
+	}
+	// 4 events at 20/s: the last is due 150ms after the first.
+	if d := elapsed(t, &pacer{perSec: 20}, gaps...); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("20/s took %v, want about 150ms", d)
+	}
+}
+
+func TestPacerWaitCancelled(t *testing.T) {
+	p := &pacer{perSec: 0.1}
+	if err := p.wait(context.Background(), time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.wait(ctx, time.Time{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait = %v, want the context's error", err)
+	}
+}
@@ -3,19 +3,26 @@ package main
 // Redis Pub/Sub subscriber with filters
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 
+	"mysql_changelog_publisher/internal/logging"
 	"mysql_changelog_publisher/internal/subscriber"
 )
 
 func main() {
+	if err := logging.Setup(os.Getenv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	cfg, err := loadConfigFromEnv()
 	if err != nil {
-		log.Fatalf("load config: %v", err)
+		slog.Error("load config", logging.Err(err))
+		os.Exit(1)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -23,15 +30,16 @@ func main() {
 
 	go func() {
 		if err := subscriber.Run(ctx, cfg); err != nil && err != context.Canceled {
-			log.Printf("subscriber exited: %v", err)
+			slog.Error("subscriber exited", logging.Err(err))
 		}
 	}()
+	go logging.ToggleDebugOnSignal(ctx)
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	select {
 	case <-sig:
-		log.Println("shutting down…")
+		slog.Info("shutting down")
 		cancel()
 	case <-ctx.Done():
 	}
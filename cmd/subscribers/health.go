@@ -0,0 +1,71 @@
+package main
+
+// HTTP endpoints: metrics, liveness and readiness
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/subscriber"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type healthReport struct {
+	Status      string                    `json:"status"`
+	Subscribers []subscriber.StatusReport `json:"subscribers"`
+}
+
+// healthHandler reports every subscriber. Liveness fails once any subscriber
+// has failed (the runner does not restart them); readiness also needs every
+// subscriber running against a reachable source.
+func healthHandler(statuses []*subscriber.Status, ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), 2*time.Second)
+		defer cancel()
+
+		r := healthReport{Status: "ok"}
+		ok := true
+		for _, st := range statuses {
+			sr, subReady := st.Report(ctx)
+			r.Subscribers = append(r.Subscribers, sr)
+			if sr.State == subscriber.StateFailed || (ready && !subReady) {
+				ok = false
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			r.Status = "unavailable"
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(r)
+	}
+}
+
+// serveHTTP exposes /metrics, /healthz, /readyz and /loglevel (read or change
+// the log level) on addr until ctx is done.
+func serveHTTP(ctx context.Context, addr string, statuses []*subscriber.Status) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", healthHandler(statuses, false))
+	mux.Handle("/readyz", healthHandler(statuses, true))
+	mux.Handle("/loglevel", logging.LevelHandler())
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Serving /metrics, /healthz, /readyz and /loglevel", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("http server", logging.Err(err))
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -16,27 +16,37 @@ import (
 	"time"
 
 	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/rotate"
 	"mysql_changelog_publisher/internal/subscriber"
+	"mysql_changelog_publisher/internal/tracing"
 
 	"github.com/redis/go-redis/v9"
 )
 
-var apiLogger *log.Logger
+var (
+	apiLogger  *slog.Logger
+	apiLogFile *rotate.Writer
+)
 
 func main() {
+	if err := logging.Setup(os.Getenv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	cfg, err := loadConfig()
 	if err != nil {
-		log.Fatalf("load config: %v", err)
+		fatal("load config", logging.Err(err))
 	}
 
 	apiURL := os.Getenv("API_URL")
 	if strings.TrimSpace(apiURL) == "" {
-		log.Fatalf("API_URL is required")
+		fatal("API_URL is required")
 	}
 
 	// Setup API log file
 	if err := setupAPILogger(); err != nil {
-		log.Fatalf("setup api logger: %v", err)
+		fatal("setup api logger", logging.Err(err))
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -44,34 +54,44 @@ func main() {
 
 	go func() {
 		if err := runWithAPIHandler(ctx, cfg, apiURL); err != nil && err != context.Canceled {
-			log.Printf("subscriber exited: %v", err)
+			slog.Error("subscriber exited", logging.Err(err))
 		}
 	}()
+	go logging.ToggleDebugOnSignal(ctx)
+	go rotate.ReopenOnSignal(ctx, apiLogFile)
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	select {
 	case <-sig:
-		log.Println("shutting down…")
+		slog.Info("shutting down")
 		cancel()
 	case <-ctx.Done():
 	}
+	apiLogFile.Close()
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func setupAPILogger() error {
-	logDir := filepath.Join("cmd", "subscribers", "lead_events")
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	rotation, err := rotate.OptionsFromEnv("API_LOG_", os.Getenv, rotate.Options{
+		MaxBytes: 100 << 20, // 100 MiB
+		MaxFiles: 10,
+	})
+	if err != nil {
 		return err
 	}
-
-	logFile := filepath.Join(logDir, "api_calls.log")
-	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	logFile := filepath.Join("cmd", "subscribers", "lead_events", "api_calls.log")
+	apiLogFile, err = rotate.OpenWith(logFile, rotation)
 	if err != nil {
 		return err
 	}
 
-	apiLogger = log.New(io.MultiWriter(os.Stdout, f), "[API] ", log.LstdFlags)
-	log.Printf("API call logs will be written to: %s", logFile)
+	apiLogger = logging.New(io.MultiWriter(os.Stdout, apiLogFile)).With(logging.KeySubscriber, "lead_events", "log", "api")
+	slog.Info("API call logs will be written to file", "file", logFile)
 	return nil
 }
 
@@ -92,14 +112,19 @@ func loadConfig() (*subscriber.Config, error) {
 }
 
 func runWithAPIHandler(ctx context.Context, cfg *subscriber.Config, apiURL string) error {
-	logPrefix := "[lead_events] "
-	log.Printf("%ssubscriber start | redis=%s db=%d channel=%s | api=%s", logPrefix, cfg.RedisAddr, cfg.RedisDB, cfg.RedisChannel, apiURL)
+	logger := slog.With(logging.KeySubscriber, "lead_events")
+	// Decrypts and verifies payloads as EVENT_KEYRING_FILE,
+	// EVENT_VERIFY_KEY_FILE and REQUIRE_SIGNED_EVENTS say.
+	opener, err := subscriber.NewPayloadOpener(cfg)
+	if err != nil {
+		return err
+	}
+	logger.Info("subscriber start", "redis", cfg.Redis.String(), "channel", cfg.RedisChannel, "api", apiURL)
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisAddr,
-		Password: cfg.RedisPass,
-		DB:       cfg.RedisDB,
-	})
+	client, err := cfg.RedisClient()
+	if err != nil {
+		return err
+	}
 	defer client.Close()
 
 	pubsub := client.Subscribe(ctx, cfg.RedisChannel)
@@ -113,19 +138,27 @@ func runWithAPIHandler(ctx context.Context, cfg *subscriber.Config, apiURL strin
 		case <-ctx.Done():
 			_ = pubsub.Close()
 			_ = client.Close()
+			if opener.Undecryptable() > 0 || opener.Rejected() > 0 {
+				logger.Warn("dropped messages", "undecryptable", opener.Undecryptable(), "rejected", opener.Rejected())
+			}
 			return ctx.Err()
 		case msg := <-msgs:
 			if msg == nil || msg.Payload == "" {
 				continue
 			}
-			if err := handleEvent(msg.Payload, filter, apiURL, logPrefix); err != nil {
-				log.Printf("%shandler error: %v", logPrefix, err)
+			raw, err := opener.Open(msg.Payload)
+			if err != nil {
+				logger.Warn("dropping message", "undecryptable", opener.Undecryptable(), "rejected", opener.Rejected(), logging.Err(err))
+				continue
+			}
+			if err := handleEvent(raw, filter, apiURL, logger); err != nil {
+				logger.Error("handler error", logging.Err(err))
 			}
 		}
 	}
 }
 
-func handleEvent(raw string, filter *subscriber.Filter, apiURL string, logPrefix string) error {
+func handleEvent(raw string, filter *subscriber.Filter, apiURL string, logger *slog.Logger) error {
 	var ev event.RowEvent
 	dec := json.NewDecoder(strings.NewReader(raw))
 	dec.UseNumber()
@@ -133,19 +166,22 @@ func handleEvent(raw string, filter *subscriber.Filter, apiURL string, logPrefix
 		return fmt.Errorf("json decode: %w", err)
 	}
 
+	logger = logger.With(logging.KeyDB, ev.DB, logging.KeyTable, ev.Table, logging.KeyOp, ev.Op, logging.KeyRowKey, ev.RowKey)
 	if !filter.Matches(&ev) {
+		logger.Debug("event filtered")
 		return nil
 	}
 
-	log.Printf("%sevent matched: op=%s table=%s key=%v", logPrefix, ev.Op, ev.Table, ev.RowKey)
+	logger.Info("event matched")
 
 	// Call API
 	if err := callAPI(apiURL, &ev); err != nil {
-		apiLogger.Printf("FAILED | URL=%s | Error=%v | Event: op=%s table=%s key=%v", apiURL, err, ev.Op, ev.Table, ev.RowKey)
+		apiLogger.Error("api call failed", "url", apiURL, logging.KeyDB, ev.DB, logging.KeyTable, ev.Table,
+			logging.KeyOp, ev.Op, logging.KeyRowKey, ev.RowKey, logging.Err(err))
 		return fmt.Errorf("api call: %w", err)
 	}
 
-	log.Printf("%sAPI called successfully", logPrefix)
+	logger.Info("API called successfully")
 	return nil
 }
 
@@ -160,6 +196,8 @@ func callAPI(url string, ev *event.RowEvent) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	// Pass the emitter's trace context on to the API.
+	tracing.Inject(tracing.Extract(context.Background(), ev.TraceParent), req.Header)
 
 	startTime := time.Now()
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -174,13 +212,13 @@ func callAPI(url string, ev *event.RowEvent) error {
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		apiLogger.Printf("ERROR | Status=%d | Duration=%v | URL=%s | Response=%s | Payload=%s",
-			resp.StatusCode, duration, url, string(body), string(payload))
+		apiLogger.Error("api call returned an error status", logging.KeyStatus, resp.StatusCode, logging.KeyDuration, duration,
+			"url", url, "response", string(body), "payload", string(payload))
 		return fmt.Errorf("api returned %d: %s", resp.StatusCode, string(body))
 	}
 
-	apiLogger.Printf("SUCCESS | Status=%d | Duration=%v | URL=%s | Response=%s | Event: op=%s table=%s key=%v",
-		resp.StatusCode, duration, url, string(body), ev.Op, ev.Table, ev.RowKey)
+	apiLogger.Info("api call succeeded", logging.KeyStatus, resp.StatusCode, logging.KeyDuration, duration, "url", url,
+		"response", string(body), logging.KeyDB, ev.DB, logging.KeyTable, ev.Table, logging.KeyOp, ev.Op, logging.KeyRowKey, ev.RowKey)
 
 	return nil
 }
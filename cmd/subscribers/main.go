@@ -5,9 +5,10 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
@@ -19,67 +20,130 @@ import (
 	"time"
 
 	"mysql_changelog_publisher/internal/event"
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/rotate"
 	"mysql_changelog_publisher/internal/subscriber"
+	"mysql_changelog_publisher/internal/tracing"
 
-	"github.com/redis/go-redis/v9"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultAPILogMaxBytes = int64(100 << 20) // 100 MiB
+	defaultAPILogMaxFiles = 10
 )
 
 type debouncer struct {
 	mu       sync.Mutex
 	pending  map[string]*pendingEvent
 	duration time.Duration
+	depth    prometheus.Gauge
 }
 
 type pendingEvent struct {
 	event     *event.RowEvent
+	msgs      []heldMessage // acked once the API call succeeds
+	received  time.Time
 	timer     *time.Timer
 	apiURL    string
-	apiLogger *log.Logger
-	logPrefix string
+	apiLogger *slog.Logger
+	logger    *slog.Logger
+	metrics   *subscriberMetrics
+}
+
+// heldMessage is a message kept from redelivery while its debounced API call
+// is pending.
+type heldMessage struct {
+	msg     *subscriber.Message
+	release func()
+}
+
+func hold(msg *subscriber.Message) heldMessage {
+	return heldMessage{msg: msg, release: msg.Hold()}
 }
 
 func main() {
+	if err := logging.Setup(os.Getenv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 	files := envFilesList()
 	if len(files) == 0 {
-		log.Fatalf("ENV_FILES (or ENV_FILE) is required")
+		fatal("ENV_FILES (or ENV_FILE) is required")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	shutdownTracing, err := tracing.Setup(ctx, "cdc-subscribers")
+	if err != nil {
+		fatal("tracing", logging.Err(err))
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Warn("flush traces", logging.Err(err))
+		}
+	}()
+	if tracing.Enabled() {
+		slog.Info("Exporting API call spans over OTLP")
+	}
+
+	var statuses []*subscriber.Status
+	var logFiles []*rotate.Writer
 	var wg sync.WaitGroup
 	for _, f := range files {
 		cfg, apiURL, apiLogger, logFile, debounceSeconds, err := loadSubscriberConfig(f)
 		if err != nil {
-			log.Fatalf("load config %s: %v", f, err)
+			fatal("load config", "file", f, logging.Err(err))
 		}
 		if strings.TrimSpace(cfg.Name) == "" {
 			cfg.Name = strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))
 		}
+		cfg.Status = subscriber.NewStatus(cfg.Name)
+		cfg.Status.HeartbeatMaxAge = cfg.HeartbeatMaxAge
+		cfg.Metrics = newSubscriberMetrics(cfg.Name)
+		statuses = append(statuses, cfg.Status)
+
+		if logFile != nil {
+			logFiles = append(logFiles, logFile)
+		}
 
 		wg.Add(1)
-		go func(c *subscriber.Config, url string, logger *log.Logger, lf *os.File, debounce int) {
+		go func(c *subscriber.Config, url string, apiLogger *slog.Logger, lf *rotate.Writer, debounce int) {
 			defer wg.Done()
 			if lf != nil {
 				defer lf.Close()
 			}
+			var err error
 			if url != "" {
-				if err := runWithAPI(ctx, c, url, logger, debounce); err != nil && err != context.Canceled {
-					log.Printf("subscriber %s exited: %v", c.Name, err)
-				}
+				err = runWithAPI(ctx, c, url, apiLogger, debounce)
 			} else {
-				if err := subscriber.Run(ctx, c); err != nil && err != context.Canceled {
-					log.Printf("subscriber %s exited: %v", c.Name, err)
-				}
+				err = subscriber.Run(ctx, c)
 			}
+			if err != nil && err != context.Canceled {
+				slog.Error("subscriber exited", logging.KeySubscriber, c.Name, logging.Err(err))
+			}
+			c.Status.Stopped(err)
 		}(cfg, apiURL, apiLogger, logFile, debounceSeconds)
 	}
 
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go serveHTTP(ctx, addr, statuses)
+	}
+	go runNotifier(ctx, statuses)
+	go logging.ToggleDebugOnSignal(ctx)
+	go rotate.ReopenOnSignal(ctx, logFiles...)
+
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	select {
 	case <-sig:
-		log.Println("shutting down…")
+		slog.Info("shutting down")
 		cancel()
 	case <-ctx.Done():
 	}
@@ -87,6 +151,11 @@ func main() {
 	wg.Wait()
 }
 
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func envFilesList() []string {
 	files := os.Getenv("ENV_FILES")
 	if strings.TrimSpace(files) == "" {
@@ -99,11 +168,11 @@ func envFilesList() []string {
 	// Auto-discover .env.* files
 	discovered, err := discoverEnvFiles()
 	if err != nil {
-		log.Printf("auto-discover env files: %v", err)
+		slog.Error("auto-discover env files", logging.Err(err))
 		return nil
 	}
 	if len(discovered) > 0 {
-		log.Printf("auto-discovered %d subscriber config(s): %v", len(discovered), discovered)
+		slog.Info("auto-discovered subscriber configs", "files", discovered)
 	}
 	return discovered
 }
@@ -136,7 +205,7 @@ func loadConfigFromFile(path string) (*subscriber.Config, error) {
 	return subscriber.LoadConfigFromMap(vals), nil
 }
 
-func loadSubscriberConfig(path string) (*subscriber.Config, string, *log.Logger, *os.File, int, error) {
+func loadSubscriberConfig(path string) (*subscriber.Config, string, *slog.Logger, *rotate.Writer, int, error) {
 	vals, err := subscriber.LoadEnvFiles([]string{path})
 	if err != nil {
 		return nil, "", nil, nil, 0, err
@@ -150,120 +219,193 @@ func loadSubscriberConfig(path string) (*subscriber.Config, string, *log.Logger,
 		debounceSeconds, _ = strconv.Atoi(d)
 	}
 
-	var apiLogger *log.Logger
-	var logFileHandle *os.File
+	var apiLogger *slog.Logger
+	var logFileHandle *rotate.Writer
 	if strings.TrimSpace(apiURL) != "" {
 		// Setup API logger for this subscriber
-		logDir := filepath.Join("logs", cfg.Name)
-		if err := os.MkdirAll(logDir, 0755); err != nil {
+		rotation, err := rotate.OptionsFromEnv("API_LOG_", func(k string) string { return vals[k] }, rotate.Options{
+			MaxBytes: defaultAPILogMaxBytes,
+			MaxFiles: defaultAPILogMaxFiles,
+		})
+		if err != nil {
 			return nil, "", nil, nil, 0, err
 		}
-
-		logFilePath := filepath.Join(logDir, "api_calls.log")
-		logFileHandle, err = os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		logFilePath := filepath.Join("logs", cfg.Name, "api_calls.log")
+		logFileHandle, err = rotate.OpenWith(logFilePath, rotation)
 		if err != nil {
 			return nil, "", nil, nil, 0, err
 		}
 
-		apiLogger = log.New(io.MultiWriter(os.Stdout, logFileHandle), fmt.Sprintf("[%s-API] ", cfg.Name), log.LstdFlags)
-		log.Printf("API logs for %s: %s", cfg.Name, logFilePath)
+		apiLogger = logging.New(io.MultiWriter(os.Stdout, logFileHandle)).With(logging.KeySubscriber, cfg.Name, "log", "api")
+		slog.Info("API logs", logging.KeySubscriber, cfg.Name, "file", logFilePath)
 		if debounceSeconds > 0 {
-			log.Printf("Debouncing enabled for %s: %d seconds", cfg.Name, debounceSeconds)
+			slog.Info("Debouncing enabled", logging.KeySubscriber, cfg.Name, "seconds", debounceSeconds)
 		}
 	}
 
 	return cfg, apiURL, apiLogger, logFileHandle, debounceSeconds, nil
 }
 
-func runWithAPI(ctx context.Context, cfg *subscriber.Config, apiURL string, apiLogger *log.Logger, debounceSeconds int) error {
-	logPrefix := fmt.Sprintf("[%s] ", cfg.Name)
-	log.Printf("%ssubscriber start | redis=%s db=%d channel=%s | api=%s", logPrefix, cfg.RedisAddr, cfg.RedisDB, cfg.RedisChannel, apiURL)
-
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisAddr,
-		Password: cfg.RedisPass,
-		DB:       cfg.RedisDB,
-	})
-	defer client.Close()
+func runWithAPI(ctx context.Context, cfg *subscriber.Config, apiURL string, apiLogger *slog.Logger, debounceSeconds int) error {
+	logger := slog.With(logging.KeySubscriber, cfg.Name)
+	opener, err := subscriber.NewPayloadOpener(cfg)
+	if err != nil {
+		return err
+	}
 
-	pubsub := client.Subscribe(ctx, cfg.RedisChannel)
-	defer pubsub.Close()
+	src, err := subscriber.OpenSource(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	logger.Info("subscriber start", "source", src.String(), "api", apiURL)
+	cfg.Status.Running(src)
 
 	filter := subscriber.NewFilter(cfg)
+	m := newSubscriberMetrics(cfg.Name)
 
 	var deb *debouncer
 	if debounceSeconds > 0 {
 		deb = &debouncer{
 			pending:  make(map[string]*pendingEvent),
 			duration: time.Duration(debounceSeconds) * time.Second,
+			depth:    m.debounced,
 		}
 	}
 
-	msgs := pubsub.Channel(redis.WithChannelHealthCheckInterval(10 * time.Second))
 	for {
-		select {
-		case <-ctx.Done():
-			_ = pubsub.Close()
-			_ = client.Close()
-			return ctx.Err()
-		case msg := <-msgs:
-			if msg == nil || msg.Payload == "" {
-				continue
+		msg, err := src.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				return fmt.Errorf("receive: %w", err)
 			}
-			if deb != nil {
-				if err := handleEventWithDebounce(msg.Payload, filter, apiURL, apiLogger, logPrefix, deb); err != nil {
-					log.Printf("%shandler error: %v", logPrefix, err)
-				}
+			_ = src.Close()
+			if opener.Undecryptable() > 0 || opener.Rejected() > 0 {
+				logger.Warn("dropped messages", "undecryptable", opener.Undecryptable(), "rejected", opener.Rejected())
+			}
+			return ctx.Err()
+		}
+		received := time.Now()
+		m.Received()
+		cfg.Status.Received()
+		raw, err := opener.Open(msg.Payload)
+		if err != nil {
+			if errors.Is(err, subscriber.ErrUnverified) {
+				m.DecodeError("unverified")
 			} else {
-				if err := handleEventWithAPI(msg.Payload, filter, apiURL, apiLogger, logPrefix); err != nil {
-					log.Printf("%shandler error: %v", logPrefix, err)
-				}
+				m.DecodeError("undecryptable")
 			}
+			logger.Warn("dropping message", "undecryptable", opener.Undecryptable(), "rejected", opener.Rejected(), logging.Err(err))
+			_ = msg.Term()
+			continue
+		}
+		if deb != nil {
+			// A debounced event is acked when its API call is made.
+			var deferred bool
+			deferred, err = handleEventWithDebounce(raw, msg, received, filter, cfg.Status, apiURL, apiLogger, logger, deb, m)
+			if deferred {
+				continue
+			}
+		} else {
+			err = handleEventWithAPI(raw, received, filter, cfg.Status, apiURL, apiLogger, logger, m)
+		}
+		settle(msg, err, logger)
+	}
+}
+
+// errBadEvent marks messages that can never be handled, so they are not
+// redelivered.
+var errBadEvent = errors.New("bad event")
+
+// settle acks msg once handled, terminates it when it can never be handled
+// and otherwise asks the source to redeliver it.
+func settle(msg *subscriber.Message, err error, logger *slog.Logger) {
+	switch {
+	case err == nil:
+		if err := msg.Ack(); err != nil {
+			logger.Warn("ack", logging.Err(err))
+		}
+	case errors.Is(err, errBadEvent):
+		logger.Error("handler error", logging.Err(err))
+		_ = msg.Term()
+	default:
+		logger.Error("handler error; message will be redelivered", logging.Err(err))
+		if err := msg.Nak(); err != nil {
+			logger.Warn("nak", logging.Err(err))
 		}
 	}
 }
 
-func handleEventWithDebounce(raw string, filter *subscriber.Filter, apiURL string, apiLogger *log.Logger, logPrefix string, deb *debouncer) error {
+// handleEventWithDebounce schedules the API call for a matching event. It
+// reports deferred when msg now waits for that call, which settles it.
+func handleEventWithDebounce(raw string, msg *subscriber.Message, received time.Time, filter *subscriber.Filter, st *subscriber.Status, apiURL string, apiLogger *slog.Logger, logger *slog.Logger, deb *debouncer, m *subscriberMetrics) (deferred bool, err error) {
 	var ev event.RowEvent
 	dec := json.NewDecoder(strings.NewReader(raw))
 	dec.UseNumber()
 	if err := dec.Decode(&ev); err != nil {
-		return fmt.Errorf("json decode: %w", err)
+		m.DecodeError("json")
+		return false, fmt.Errorf("%w: json decode: %w", errBadEvent, err)
+	}
+	if ev.Op == event.OpHeartbeat {
+		lag := st.Heartbeat(ev.Heartbeat)
+		m.Heartbeat(lag)
+		logger.Debug("heartbeat received", "lag", lag)
+		return false, nil
 	}
+	logger = logger.With(logging.KeyDB, ev.DB, logging.KeyTable, ev.Table, logging.KeyOp, ev.Op, logging.KeyRowKey, ev.RowKey)
 
 	if !filter.Matches(&ev) {
-		return nil
+		m.Filtered()
+		logger.Debug("event filtered")
+		return false, nil
 	}
+	m.Matched()
 
-	log.Printf("%sevent matched (debouncing): op=%s table=%s key=%v", logPrefix, ev.Op, ev.Table, ev.RowKey)
+	logger.Info("event matched (debouncing)")
 
 	// Create unique key for this row
 	rowID := fmt.Sprintf("%s:%s:%v", ev.DB, ev.Table, ev.RowKey)
 
 	deb.mu.Lock()
 	if existing, ok := deb.pending[rowID]; ok {
+		for i, h := range existing.msgs {
+			if msg.Seq != 0 && h.msg.Seq == msg.Seq {
+				// A redelivery of a message already waiting: settle it through
+				// the newer delivery, but don't postpone the call.
+				h.release()
+				existing.msgs[i] = hold(msg)
+				deb.mu.Unlock()
+				return true, nil
+			}
+		}
 		// Cancel existing timer and update event
 		existing.timer.Stop()
 		existing.event = &ev
+		existing.msgs = append(existing.msgs, hold(msg))
+		existing.received = received
 		existing.timer = time.AfterFunc(deb.duration, func() {
 			callDebouncedAPI(rowID, deb)
 		})
 		deb.mu.Unlock()
-		return nil
+		return true, nil
 	}
 
 	// New event - schedule API call
 	deb.pending[rowID] = &pendingEvent{
 		event:     &ev,
+		msgs:      []heldMessage{hold(msg)},
+		received:  received,
 		apiURL:    apiURL,
 		apiLogger: apiLogger,
-		logPrefix: logPrefix,
+		logger:    logger,
+		metrics:   m,
 		timer: time.AfterFunc(deb.duration, func() {
 			callDebouncedAPI(rowID, deb)
 		}),
 	}
+	deb.depth.Set(float64(len(deb.pending)))
 	deb.mu.Unlock()
-	return nil
+	return true, nil
 }
 
 func callDebouncedAPI(rowID string, deb *debouncer) {
@@ -274,51 +416,88 @@ func callDebouncedAPI(rowID string, deb *debouncer) {
 		return
 	}
 	delete(deb.pending, rowID)
+	deb.depth.Set(float64(len(deb.pending)))
 	deb.mu.Unlock()
 
-	if err := callAPI(pe.apiURL, pe.event, pe.apiLogger); err != nil {
-		pe.apiLogger.Printf("FAILED | URL=%s | Error=%v | Event: op=%s table=%s key=%v", pe.apiURL, err, pe.event.Op, pe.event.Table, pe.event.RowKey)
-		log.Printf("%sAPI call failed: %v", pe.logPrefix, err)
+	err := callAPI(pe.apiURL, pe.event, pe.received, pe.apiLogger, pe.metrics)
+	if err != nil {
+		pe.apiLogger.Error("api call failed", "url", pe.apiURL, logging.KeyDB, pe.event.DB, logging.KeyTable, pe.event.Table,
+			logging.KeyOp, pe.event.Op, logging.KeyRowKey, pe.event.RowKey, logging.Err(err))
+		err = fmt.Errorf("api call: %w", err)
 	} else {
-		log.Printf("%sAPI called successfully (debounced)", pe.logPrefix)
+		pe.logger.Info("API called successfully (debounced)")
+	}
+	// The call stood for every event it superseded.
+	for _, h := range pe.msgs {
+		h.release()
+		settle(h.msg, err, pe.logger)
 	}
 }
 
-func handleEventWithAPI(raw string, filter *subscriber.Filter, apiURL string, apiLogger *log.Logger, logPrefix string) error {
+func handleEventWithAPI(raw string, received time.Time, filter *subscriber.Filter, st *subscriber.Status, apiURL string, apiLogger *slog.Logger, logger *slog.Logger, m *subscriberMetrics) error {
 	var ev event.RowEvent
 	dec := json.NewDecoder(strings.NewReader(raw))
 	dec.UseNumber()
 	if err := dec.Decode(&ev); err != nil {
-		return fmt.Errorf("json decode: %w", err)
+		m.DecodeError("json")
+		return fmt.Errorf("%w: json decode: %w", errBadEvent, err)
 	}
+	if ev.Op == event.OpHeartbeat {
+		lag := st.Heartbeat(ev.Heartbeat)
+		m.Heartbeat(lag)
+		logger.Debug("heartbeat received", "lag", lag)
+		return nil
+	}
+	logger = logger.With(logging.KeyDB, ev.DB, logging.KeyTable, ev.Table, logging.KeyOp, ev.Op, logging.KeyRowKey, ev.RowKey)
 
 	if !filter.Matches(&ev) {
+		m.Filtered()
+		logger.Debug("event filtered")
 		return nil
 	}
+	m.Matched()
 
-	log.Printf("%sevent matched: op=%s table=%s key=%v", logPrefix, ev.Op, ev.Table, ev.RowKey)
+	logger.Info("event matched")
 
 	// Call API
-	if err := callAPI(apiURL, &ev, apiLogger); err != nil {
-		apiLogger.Printf("FAILED | URL=%s | Error=%v | Event: op=%s table=%s key=%v", apiURL, err, ev.Op, ev.Table, ev.RowKey)
+	if err := callAPI(apiURL, &ev, received, apiLogger, m); err != nil {
+		apiLogger.Error("api call failed", "url", apiURL, logging.KeyDB, ev.DB, logging.KeyTable, ev.Table,
+			logging.KeyOp, ev.Op, logging.KeyRowKey, ev.RowKey, logging.Err(err))
 		return fmt.Errorf("api call: %w", err)
 	}
 
-	log.Printf("%sAPI called successfully", logPrefix)
+	logger.Info("API called successfully")
 	return nil
 }
 
-func callAPI(url string, ev *event.RowEvent, apiLogger *log.Logger) error {
+// callAPI posts ev to url. The request carries a traceparent header for a
+// span that continues the emitter's trace and covers received (including
+// any debounce wait) to the API's response.
+func callAPI(url string, ev *event.RowEvent, received time.Time, apiLogger *slog.Logger, m *subscriberMetrics) error {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(context.Background(), ev.TraceParent), "callAPI "+ev.DB+"."+ev.Table,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(received),
+		trace.WithAttributes(
+			attribute.String("cdc.subscriber", m.name),
+			attribute.String("cdc.op", ev.Op),
+			attribute.String("http.request.method", "POST"),
+			attribute.String("url.full", url),
+		))
+	defer span.End()
+
 	payload, err := json.Marshal(ev)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	tracing.Inject(ctx, req.Header)
 
 	startTime := time.Now()
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -326,20 +505,27 @@ func callAPI(url string, ev *event.RowEvent, apiLogger *log.Logger) error {
 	duration := time.Since(startTime)
 
 	if err != nil {
+		m.apiCall(0, duration)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
+	m.apiCall(resp.StatusCode, duration)
+	m.latency(ev, received, time.Now())
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		apiLogger.Printf("ERROR | Status=%d | Duration=%v | URL=%s | Response=%s | Payload=%s",
-			resp.StatusCode, duration, url, string(body), string(payload))
+		apiLogger.Error("api call returned an error status", logging.KeyStatus, resp.StatusCode, logging.KeyDuration, duration,
+			"url", url, "response", string(body), "payload", string(payload))
+		span.SetStatus(codes.Error, fmt.Sprintf("api returned %d", resp.StatusCode))
 		return fmt.Errorf("api returned %d: %s", resp.StatusCode, string(body))
 	}
 
-	apiLogger.Printf("SUCCESS | Status=%d | Duration=%v | URL=%s | Response=%s | Event: op=%s table=%s key=%v",
-		resp.StatusCode, duration, url, string(body), ev.Op, ev.Table, ev.RowKey)
+	apiLogger.Info("api call succeeded", logging.KeyStatus, resp.StatusCode, logging.KeyDuration, duration, "url", url,
+		"response", string(body), logging.KeyDB, ev.DB, logging.KeyTable, ev.Table, logging.KeyOp, ev.Op, logging.KeyRowKey, ev.RowKey)
 
 	return nil
 }
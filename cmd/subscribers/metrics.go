@@ -0,0 +1,162 @@
+package main
+
+// Prometheus metrics for the subscriber runner
+import (
+	"strconv"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const metricsNamespace = "cdc_subscriber"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	receivedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received from the source.",
+	}, []string{"subscriber"})
+	decodeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "decode_errors_total",
+		Help:      "Messages dropped before filtering, by reason (json, undecryptable, unverified).",
+	}, []string{"subscriber", "reason"})
+	filteredTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_filtered_total",
+		Help:      "Events that did not match the subscriber's filters.",
+	}, []string{"subscriber"})
+	matchedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_matched_total",
+		Help:      "Events that matched the subscriber's filters.",
+	}, []string{"subscriber"})
+	apiCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_calls_total",
+		Help:      "API calls by HTTP status code (\"error\" when no response was received).",
+	}, []string{"subscriber", "code"})
+	apiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "api_call_duration_seconds",
+		Help:      "API call latency.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"subscriber"})
+	debouncePending = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "debounce_pending",
+		Help:      "Rows waiting for their debounced API call.",
+	}, []string{"subscriber"})
+	heartbeatLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "heartbeat_lag_seconds",
+		Help:      "Time from the emitter writing the last heartbeat row to its heartbeat event arriving here.",
+	}, []string{"subscriber"})
+	lastHeartbeat = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_heartbeat_timestamp_seconds",
+		Help:      "When the last heartbeat event arrived.",
+	}, []string{"subscriber"})
+	eventLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_latency_seconds",
+		Help:      "Latency of events that reached the API, by stage (commit_to_emit, emit_to_receive, receive_to_api, commit_to_api).",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"subscriber", "stage"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		receivedTotal, decodeErrorsTotal, filteredTotal, matchedTotal,
+		apiCallsTotal, apiDuration, debouncePending,
+		heartbeatLag, lastHeartbeat, eventLatency,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// subscriberMetrics holds the metrics of one subscriber. It implements
+// subscriber.Metrics, so subscribers without an API record them too.
+type subscriberMetrics struct {
+	name      string
+	received  prometheus.Counter
+	filtered  prometheus.Counter
+	matched   prometheus.Counter
+	apiTime   prometheus.Observer
+	debounced prometheus.Gauge
+	hbLag     prometheus.Gauge
+	hbLast    prometheus.Gauge
+
+	commitToEmit  prometheus.Observer
+	emitToReceive prometheus.Observer
+	receiveToAPI  prometheus.Observer
+	commitToAPI   prometheus.Observer
+}
+
+func newSubscriberMetrics(name string) *subscriberMetrics {
+	return &subscriberMetrics{
+		name:      name,
+		received:  receivedTotal.WithLabelValues(name),
+		filtered:  filteredTotal.WithLabelValues(name),
+		matched:   matchedTotal.WithLabelValues(name),
+		apiTime:   apiDuration.WithLabelValues(name),
+		debounced: debouncePending.WithLabelValues(name),
+		hbLag:     heartbeatLag.WithLabelValues(name),
+		hbLast:    lastHeartbeat.WithLabelValues(name),
+
+		commitToEmit:  eventLatency.WithLabelValues(name, "commit_to_emit"),
+		emitToReceive: eventLatency.WithLabelValues(name, "emit_to_receive"),
+		receiveToAPI:  eventLatency.WithLabelValues(name, "receive_to_api"),
+		commitToAPI:   eventLatency.WithLabelValues(name, "commit_to_api"),
+	}
+}
+
+func (m *subscriberMetrics) Received() { m.received.Inc() }
+func (m *subscriberMetrics) Filtered() { m.filtered.Inc() }
+func (m *subscriberMetrics) Matched()  { m.matched.Inc() }
+
+func (m *subscriberMetrics) DecodeError(reason string) {
+	decodeErrorsTotal.WithLabelValues(m.name, reason).Inc()
+}
+
+// Heartbeat records a heartbeat event that took lag to arrive.
+func (m *subscriberMetrics) Heartbeat(lag time.Duration) {
+	m.hbLag.Set(lag.Seconds())
+	m.hbLast.SetToCurrentTime()
+}
+
+// latency records the stages of an event that was received at received and
+// whose API call completed at done. Stages needing a timestamp the emitter
+// did not set (older emitters) are skipped.
+func (m *subscriberMetrics) latency(ev *event.RowEvent, received, done time.Time) {
+	observe := func(o prometheus.Observer, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			o.Observe(max(0, to.Sub(from)).Seconds())
+		}
+	}
+	commit, emit := parseTime(ev.CommitTime), parseTime(ev.EmitTime)
+	observe(m.commitToEmit, commit, emit)
+	observe(m.emitToReceive, emit, received)
+	observe(m.receiveToAPI, received, done)
+	observe(m.commitToAPI, commit, done)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
+
+// apiCall records one API call; code is 0 when no response was received.
+func (m *subscriberMetrics) apiCall(code int, d time.Duration) {
+	label := "error"
+	if code > 0 {
+		label = strconv.Itoa(code)
+	}
+	apiCallsTotal.WithLabelValues(m.name, label).Inc()
+	m.apiTime.Observe(d.Seconds())
+}
@@ -0,0 +1,70 @@
+package main
+
+// systemd readiness, status and watchdog notifications
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"mysql_changelog_publisher/internal/logging"
+	"mysql_changelog_publisher/internal/sdnotify"
+	"mysql_changelog_publisher/internal/subscriber"
+)
+
+// runNotifier speaks sd_notify when started by systemd (NOTIFY_SOCKET set):
+// READY once every subscriber is consuming, STATUS lines with subscriber
+// counts, and WATCHDOG pings only while every subscriber is running with a
+// reachable source.
+func runNotifier(ctx context.Context, statuses []*subscriber.Status) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	watchdog := sdnotify.WatchdogInterval()
+	interval := 10 * time.Second
+	if watchdog > 0 && watchdog/2 < interval {
+		interval = watchdog / 2
+	}
+
+	notify := func(state string) {
+		if _, err := sdnotify.Notify(state); err != nil {
+			slog.Warn("sd_notify", logging.Err(err))
+		}
+	}
+
+	ready := false
+	ticker := time.NewTicker(time.Second) // poll quickly until ready
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			notify(sdnotify.Stopping)
+			return
+		case <-ticker.C:
+		}
+
+		running, healthy := 0, 0
+		checkCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		for _, st := range statuses {
+			r, ok := st.Report(checkCtx)
+			if r.State == subscriber.StateRunning {
+				running++
+			}
+			if ok {
+				healthy++
+			}
+		}
+		cancel()
+
+		if !ready && running == len(statuses) {
+			notify(sdnotify.Ready)
+			ready = true
+			ticker.Reset(interval)
+		}
+		notify(sdnotify.Status(fmt.Sprintf("%d/%d subscribers running, %d healthy", running, len(statuses), healthy)))
+		if ready && watchdog > 0 && healthy == len(statuses) {
+			notify(sdnotify.Watchdog)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package envelope
+
+// AES-GCM envelope for event payloads in transit
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const algAES256GCM = "aes-256-gcm"
+
+var (
+	ErrUnknownKey   = errors.New("unknown key id")
+	ErrNotEncrypted = errors.New("payload is not encrypted")
+)
+
+// Keyring maps key ids to AES-256 keys. Active is the key id used to seal
+// new payloads; the remaining keys are kept so payloads sealed before a
+// rotation can still be opened.
+type Keyring struct {
+	Active string
+	keys   map[string]cipher.AEAD
+}
+
+// keyringFile is the on-disk format:
+//
+//	{"active": "2025-01", "keys": {"2024-12": "<base64>", "2025-01": "<base64>"}}
+type keyringFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+// Envelope is the wire format of a sealed payload.
+type Envelope struct {
+	Enc   string `json:"enc"`
+	KeyID string `json:"kid"`
+	Nonce string `json:"nonce"`
+	Data  string `json:"data"`
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf keyringFile
+	if err := json.Unmarshal(b, &kf); err != nil {
+		return nil, fmt.Errorf("parse keyring %s: %w", path, err)
+	}
+	if len(kf.Keys) == 0 {
+		return nil, fmt.Errorf("keyring %s has no keys", path)
+	}
+
+	kr := &Keyring{Active: kf.Active, keys: make(map[string]cipher.AEAD, len(kf.Keys))}
+	for id, enc := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(enc))
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s: key %q: %w", path, id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("keyring %s: key %q must be 32 bytes, got %d", path, id, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+	}
+	if kr.Active != "" {
+		if _, ok := kr.keys[kr.Active]; !ok {
+			return nil, fmt.Errorf("keyring %s: active key %q not found", path, kr.Active)
+		}
+	}
+	return kr, nil
+}
+
+// Seal encrypts payload with the active key and returns the marshalled
+// envelope.
+func (k *Keyring) Seal(payload []byte) ([]byte, error) {
+	aead, ok := k.keys[k.Active]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q (no active key)", ErrUnknownKey, k.Active)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ct := aead.Seal(nil, nonce, payload, []byte(k.Active))
+	return json.Marshal(Envelope{
+		Enc:   algAES256GCM,
+		KeyID: k.Active,
+		Nonce: base64.StdEncoding.EncodeToString(nonce),
+		Data:  base64.StdEncoding.EncodeToString(ct),
+	})
+}
+
+// Open decrypts a sealed payload. It returns ErrNotEncrypted when raw is not
+// an envelope so callers can decide whether to accept plaintext.
+func (k *Keyring) Open(raw []byte) ([]byte, error) {
+	env, ok := parse(raw)
+	if !ok {
+		return nil, ErrNotEncrypted
+	}
+	if env.Enc != algAES256GCM {
+		return nil, fmt.Errorf("unsupported encryption %q", env.Enc)
+	}
+	aead, ok := k.keys[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, env.KeyID)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("decode nonce: %w", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("bad nonce size %d", len(nonce))
+	}
+	ct, err := base64.StdEncoding.DecodeString(env.Data)
+	if err != nil {
+		return nil, fmt.Errorf("decode data: %w", err)
+	}
+	pt, err := aead.Open(nil, nonce, ct, []byte(env.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt with key %q: %w", env.KeyID, err)
+	}
+	return pt, nil
+}
+
+// IsSealed reports whether raw looks like an encrypted envelope.
+func IsSealed(raw []byte) bool {
+	_, ok := parse(raw)
+	return ok
+}
+
+func parse(raw []byte) (Envelope, bool) {
+	var env Envelope
+	if err := json.Unmarshal(raw, &env); err != nil || env.Enc == "" {
+		return Envelope{}, false
+	}
+	return env, true
+}
@@ -0,0 +1,149 @@
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFile writes content to name in a temp dir and returns its path.
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func key(b byte, n int) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, n))
+}
+
+func keyring(t *testing.T, active string, keys map[string]string) *Keyring {
+	t.Helper()
+	b, err := json.Marshal(keyringFile{Active: active, Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr, err := LoadKeyring(writeFile(t, "keyring.json", string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	kr := keyring(t, "k1", map[string]string{"k1": key(1, 32)})
+	payload := []byte(`{"event_id":"crm.leads:create:1"}`)
+
+	sealed, err := kr.Seal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("crm.leads")) {
+		t.Fatalf("sealed envelope contains plaintext: %s", sealed)
+	}
+	if !IsSealed(sealed) || IsSealed(payload) {
+		t.Error("IsSealed does not tell envelopes from plain JSON")
+	}
+	got, err := kr.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("Open = %s, want %s", got, payload)
+	}
+	if _, err := kr.Open(payload); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("Open(plaintext) error = %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestOpenAfterRotation(t *testing.T) {
+	old := keyring(t, "2024-12", map[string]string{"2024-12": key(1, 32)})
+	sealed, err := old.Seal([]byte(`{"n":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key is active; the old one is kept to open what it sealed.
+	rotated := keyring(t, "2025-01", map[string]string{"2024-12": key(1, 32), "2025-01": key(2, 32)})
+	if got, err := rotated.Open(sealed); err != nil || string(got) != `{"n":1}` {
+		t.Fatalf("Open(old envelope) = %s, %v", got, err)
+	}
+	resealed, err := rotated.Seal([]byte(`{"n":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env Envelope
+	if err := json.Unmarshal(resealed, &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.KeyID != "2025-01" {
+		t.Errorf("sealed with %q, want the active key", env.KeyID)
+	}
+
+	// Once the old key is dropped its envelopes can't be opened.
+	dropped := keyring(t, "2025-01", map[string]string{"2025-01": key(2, 32)})
+	if _, err := dropped.Open(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open with dropped key error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestOpenRejectsTampering(t *testing.T) {
+	kr := keyring(t, "k1", map[string]string{"k1": key(1, 32), "k2": key(2, 32)})
+	sealed, err := kr.Seal([]byte(`{"n":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var env Envelope
+	if err := json.Unmarshal(sealed, &env); err != nil {
+		t.Fatal(err)
+	}
+
+	ct, _ := base64.StdEncoding.DecodeString(env.Data)
+	ct[0] ^= 0xff
+	flipped := env
+	flipped.Data = base64.StdEncoding.EncodeToString(ct)
+
+	// The key id is authenticated data, so relabelling the envelope fails too.
+	relabelled := env
+	relabelled.KeyID = "k2"
+
+	wrongAlg := env
+	wrongAlg.Enc = "aes-128-cbc"
+
+	for name, e := range map[string]Envelope{"ciphertext": flipped, "key id": relabelled, "alg": wrongAlg} {
+		raw, _ := json.Marshal(e)
+		if got, err := kr.Open(raw); err == nil {
+			t.Errorf("%s: tampered envelope opened as %s", name, got)
+		}
+	}
+}
+
+func TestLoadKeyringErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, want string
+	}{
+		{"no keys", `{"active":"k1","keys":{}}`, "no keys"},
+		{"short key", `{"keys":{"k1":"` + key(1, 16) + `"}}`, "must be 32 bytes"},
+		{"bad base64", `{"keys":{"k1":"%%%"}}`, `key "k1"`},
+		{"missing active", `{"active":"k2","keys":{"k1":"` + key(1, 32) + `"}}`, `active key "k2" not found`},
+	} {
+		_, err := LoadKeyring(writeFile(t, "keyring.json", tc.file))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	// Without an active key the keyring can open but not seal.
+	kr := keyring(t, "", map[string]string{"k1": key(1, 32)})
+	if _, err := kr.Seal([]byte(`{}`)); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Seal without active key error = %v, want ErrUnknownKey", err)
+	}
+}
@@ -0,0 +1,190 @@
+package envelope
+
+// Message signing so subscribers can verify events came from the emitter
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	AlgHMACSHA256 = "hmac-sha256"
+	AlgEd25519    = "ed25519"
+
+	minHMACKeyBytes = 32
+)
+
+var (
+	ErrUnsigned     = errors.New("message is not signed")
+	ErrBadSignature = errors.New("bad signature")
+)
+
+// Signed is the wire format of a signed message. Payload is the event JSON
+// (or an encrypted Envelope) exactly as it was signed.
+type Signed struct {
+	Alg     string          `json:"alg"`
+	KeyID   string          `json:"kid,omitempty"`
+	Sig     string          `json:"sig"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Signer signs outgoing payloads.
+type Signer struct {
+	alg   string
+	keyID string
+	hmac  []byte
+	priv  ed25519.PrivateKey
+}
+
+// Verifier checks signatures produced by a Signer with the matching key.
+type Verifier struct {
+	alg  string
+	hmac []byte
+	pub  ed25519.PublicKey
+}
+
+// readKeyFile reads a base64 encoded key from path.
+func readKeyFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// checkHMACKey rejects shared secrets too short to be safe, on both ends.
+func checkHMACKey(key []byte) error {
+	if len(key) < minHMACKeyBytes {
+		return fmt.Errorf("hmac key must be at least %d bytes, got %d", minHMACKeyBytes, len(key))
+	}
+	return nil
+}
+
+// NewSigner loads a signing key. For hmac-sha256 the file holds the shared
+// secret; for ed25519 it holds the 32-byte seed or 64-byte private key.
+func NewSigner(alg, keyFile, keyID string) (*Signer, error) {
+	key, err := readKeyFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &Signer{alg: strings.ToLower(alg), keyID: keyID}
+	switch s.alg {
+	case AlgHMACSHA256:
+		if err := checkHMACKey(key); err != nil {
+			return nil, err
+		}
+		s.hmac = key
+	case AlgEd25519:
+		switch len(key) {
+		case ed25519.SeedSize:
+			s.priv = ed25519.NewKeyFromSeed(key)
+		case ed25519.PrivateKeySize:
+			s.priv = ed25519.PrivateKey(key)
+		default:
+			return nil, fmt.Errorf("ed25519 private key must be %d or %d bytes, got %d", ed25519.SeedSize, ed25519.PrivateKeySize, len(key))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q (want %s or %s)", alg, AlgHMACSHA256, AlgEd25519)
+	}
+	return s, nil
+}
+
+// NewVerifier loads a verification key. For hmac-sha256 the file holds the
+// shared secret; for ed25519 it holds the 32-byte public key.
+func NewVerifier(alg, keyFile string) (*Verifier, error) {
+	key, err := readKeyFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	v := &Verifier{alg: strings.ToLower(alg)}
+	switch v.alg {
+	case AlgHMACSHA256:
+		if err := checkHMACKey(key); err != nil {
+			return nil, err
+		}
+		v.hmac = key
+	case AlgEd25519:
+		if len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("ed25519 public key must be %d bytes, got %d", ed25519.PublicKeySize, len(key))
+		}
+		v.pub = ed25519.PublicKey(key)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q (want %s or %s)", alg, AlgHMACSHA256, AlgEd25519)
+	}
+	return v, nil
+}
+
+// Sign wraps payload in a Signed message.
+func (s *Signer) Sign(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, payload); err != nil {
+		return nil, fmt.Errorf("compact payload: %w", err)
+	}
+	msg := buf.Bytes()
+
+	var sig []byte
+	switch s.alg {
+	case AlgHMACSHA256:
+		mac := hmac.New(sha256.New, s.hmac)
+		mac.Write(msg)
+		sig = mac.Sum(nil)
+	case AlgEd25519:
+		sig = ed25519.Sign(s.priv, msg)
+	}
+	return json.Marshal(Signed{
+		Alg:     s.alg,
+		KeyID:   s.keyID,
+		Sig:     base64.StdEncoding.EncodeToString(sig),
+		Payload: msg,
+	})
+}
+
+// Verify checks a Signed message and returns its payload. It returns
+// ErrUnsigned when raw is not a signed message.
+func (v *Verifier) Verify(raw []byte) ([]byte, error) {
+	msg, ok := ParseSigned(raw)
+	if !ok {
+		return nil, ErrUnsigned
+	}
+	if msg.Alg != v.alg {
+		return nil, fmt.Errorf("%w: algorithm %q, expected %q", ErrBadSignature, msg.Alg, v.alg)
+	}
+	sig, err := base64.StdEncoding.DecodeString(msg.Sig)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+
+	switch v.alg {
+	case AlgHMACSHA256:
+		mac := hmac.New(sha256.New, v.hmac)
+		mac.Write(msg.Payload)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, ErrBadSignature
+		}
+	case AlgEd25519:
+		if !ed25519.Verify(v.pub, msg.Payload, sig) {
+			return nil, ErrBadSignature
+		}
+	}
+	return msg.Payload, nil
+}
+
+// ParseSigned decodes raw as a Signed message.
+func ParseSigned(raw []byte) (Signed, bool) {
+	var msg Signed
+	if err := json.Unmarshal(raw, &msg); err != nil || msg.Alg == "" || msg.Sig == "" || len(msg.Payload) == 0 {
+		return Signed{}, false
+	}
+	return msg, true
+}
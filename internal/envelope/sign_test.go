@@ -0,0 +1,115 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// signerPair returns a signer and the verifier for its key.
+func signerPair(t *testing.T, alg string) (*Signer, *Verifier) {
+	t.Helper()
+	var priv, pub string
+	switch alg {
+	case AlgHMACSHA256:
+		priv, pub = key(7, 32), key(7, 32)
+	case AlgEd25519:
+		seed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+		priv = base64.StdEncoding.EncodeToString(seed)
+		pub = base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey))
+	}
+	s, err := NewSigner(alg, writeFile(t, "sign.key", priv), "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVerifier(alg, writeFile(t, "verify.key", pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, v
+}
+
+func TestSignVerify(t *testing.T) {
+	for _, alg := range []string{AlgHMACSHA256, AlgEd25519} {
+		t.Run(alg, func(t *testing.T) {
+			s, v := signerPair(t, alg)
+			signed, err := s.Sign([]byte(`{ "event_id": "crm.leads:create:1" }`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := v.Verify(signed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Payloads are signed in compact form.
+			if string(got) != `{"event_id":"crm.leads:create:1"}` {
+				t.Errorf("Verify = %s", got)
+			}
+			if _, err := v.Verify(got); !errors.Is(err, ErrUnsigned) {
+				t.Errorf("Verify(unsigned) error = %v, want ErrUnsigned", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	s, v := signerPair(t, AlgHMACSHA256)
+	signed, err := s.Sign([]byte(`{"n":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, ok := ParseSigned(signed)
+	if !ok {
+		t.Fatalf("ParseSigned(%s) failed", signed)
+	}
+
+	payload := msg
+	payload.Payload = json.RawMessage(`{"n":2}`)
+
+	sig, _ := base64.StdEncoding.DecodeString(msg.Sig)
+	sig[0] ^= 0xff
+	badSig := msg
+	badSig.Sig = base64.StdEncoding.EncodeToString(sig)
+
+	wrongAlg := msg
+	wrongAlg.Alg = AlgEd25519
+
+	for name, m := range map[string]Signed{"payload": payload, "signature": badSig, "alg": wrongAlg} {
+		raw, _ := json.Marshal(m)
+		if got, err := v.Verify(raw); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: Verify = %s, %v; want ErrBadSignature", name, got, err)
+		}
+	}
+
+	// A different key doesn't verify either.
+	other, err := NewVerifier(AlgHMACSHA256, writeFile(t, "other.key", key(8, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Verify(signed); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify with another key error = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestSigningKeyErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, alg, key, want string
+	}{
+		{"short hmac", AlgHMACSHA256, key(1, 16), "at least 32 bytes"},
+		{"empty hmac", AlgHMACSHA256, "", "at least 32 bytes"},
+		{"bad ed25519", AlgEd25519, key(1, 31), "must be"},
+		{"unknown alg", "rsa", key(1, 32), "unsupported signing algorithm"},
+	} {
+		path := writeFile(t, "key", tc.key)
+		if _, err := NewSigner(tc.alg, path, ""); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: NewSigner error = %v, want %q", tc.name, err, tc.want)
+		}
+		if _, err := NewVerifier(tc.alg, path); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: NewVerifier error = %v, want %q", tc.name, err, tc.want)
+		}
+	}
+}
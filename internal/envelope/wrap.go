@@ -0,0 +1,75 @@
+package envelope
+
+// Sealing then signing outgoing payloads, as configured from the environment
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Wrapper seals, then signs, outgoing event JSON; either step is optional.
+// A nil Wrapper returns payloads unchanged.
+type Wrapper struct {
+	keyring *Keyring
+	signer  *Signer
+}
+
+func NewWrapper(keyring *Keyring, signer *Signer) *Wrapper {
+	return &Wrapper{keyring: keyring, signer: signer}
+}
+
+// WrapperFromEnv reads the publishing side's settings:
+//
+//	EVENT_KEYRING_FILE      keyring to seal with; its active key is required
+//	EVENT_SIGNING_KEY_FILE  signing key; unset disables signing
+//	EVENT_SIGNING_ALG       hmac-sha256 (default) or ed25519
+//	EVENT_SIGNING_KEY_ID    key id put in signed messages
+func WrapperFromEnv(get func(string) string) (*Wrapper, error) {
+	w := &Wrapper{}
+	if path := get("EVENT_KEYRING_FILE"); path != "" {
+		keyring, err := LoadKeyring(path)
+		if err != nil {
+			return nil, fmt.Errorf("load keyring: %w", err)
+		}
+		if keyring.Active == "" {
+			return nil, fmt.Errorf("keyring %s: \"active\" key id is required to encrypt", path)
+		}
+		slog.Info("Encrypting payloads", "key_id", keyring.Active)
+		w.keyring = keyring
+	}
+	if path := get("EVENT_SIGNING_KEY_FILE"); path != "" {
+		alg := strings.TrimSpace(get("EVENT_SIGNING_ALG"))
+		if alg == "" {
+			alg = AlgHMACSHA256
+		}
+		signer, err := NewSigner(alg, path, get("EVENT_SIGNING_KEY_ID"))
+		if err != nil {
+			return nil, fmt.Errorf("load signing key: %w", err)
+		}
+		slog.Info("Signing payloads", "alg", alg)
+		w.signer = signer
+	}
+	return w, nil
+}
+
+// Wrap seals payload with the active key, then signs the result.
+func (w *Wrapper) Wrap(payload []byte) ([]byte, error) {
+	if w == nil {
+		return payload, nil
+	}
+	if w.keyring != nil {
+		sealed, err := w.keyring.Seal(payload)
+		if err != nil {
+			return nil, fmt.Errorf("encrypt payload: %w", err)
+		}
+		payload = sealed
+	}
+	if w.signer != nil {
+		signed, err := w.signer.Sign(payload)
+		if err != nil {
+			return nil, fmt.Errorf("sign payload: %w", err)
+		}
+		payload = signed
+	}
+	return payload, nil
+}
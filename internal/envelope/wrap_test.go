@@ -0,0 +1,78 @@
+package envelope
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWrapperFromEnv(t *testing.T) {
+	env := map[string]string{
+		"EVENT_KEYRING_FILE":     writeFile(t, "keyring.json", `{"active":"k1","keys":{"k1":"`+key(1, 32)+`"}}`),
+		"EVENT_SIGNING_KEY_FILE": writeFile(t, "sign.key", key(7, 32)),
+		"EVENT_SIGNING_KEY_ID":   "s1",
+	}
+	w, err := WrapperFromEnv(func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := w.Wrap([]byte(`{"n":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed with the default algorithm, around the sealed event.
+	v, err := NewVerifier(AlgHMACSHA256, env["EVENT_SIGNING_KEY_FILE"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := v.Verify(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := ParseSigned(payload); msg.KeyID != "s1" {
+		t.Errorf("signed with key id %q, want s1", msg.KeyID)
+	}
+	kr, err := LoadKeyring(env["EVENT_KEYRING_FILE"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := kr.Open(sealed); err != nil || string(got) != `{"n":1}` {
+		t.Errorf("Open = %s, %v", got, err)
+	}
+}
+
+func TestWrapperFromEnvErrors(t *testing.T) {
+	noActive := writeFile(t, "keyring.json", `{"keys":{"k1":"`+key(1, 32)+`"}}`)
+	_, err := WrapperFromEnv(func(k string) string {
+		return map[string]string{"EVENT_KEYRING_FILE": noActive}[k]
+	})
+	if err == nil || !strings.Contains(err.Error(), `"active" key id is required`) {
+		t.Errorf("keyring without active key: error = %v", err)
+	}
+
+	shortKey := writeFile(t, "sign.key", key(7, 8))
+	_, err = WrapperFromEnv(func(k string) string {
+		return map[string]string{"EVENT_SIGNING_KEY_FILE": shortKey}[k]
+	})
+	if err == nil || !strings.Contains(err.Error(), "load signing key") {
+		t.Errorf("short signing key: error = %v", err)
+	}
+}
+
+func TestNilWrapperPassesThrough(t *testing.T) {
+	var w *Wrapper
+	if got, err := w.Wrap([]byte(`{"n":1}`)); err != nil || string(got) != `{"n":1}` {
+		t.Errorf("Wrap = %s, %v", got, err)
+	}
+	empty, err := WrapperFromEnv(func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := empty.Wrap([]byte(`{"n":1}`)); err != nil || string(got) != `{"n":1}` {
+		t.Errorf("Wrap = %s, %v", got, err)
+	}
+	if _, err := NewWrapper(&Keyring{}, nil).Wrap([]byte(`{}`)); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Wrap with no active key error = %v, want ErrUnknownKey", err)
+	}
+}
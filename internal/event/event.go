@@ -1,5 +1,11 @@
 package event
 
+import "encoding/json"
+
+// OpHeartbeat marks heartbeat events. They carry no row change; filters never
+// match them.
+const OpHeartbeat = "heartbeat"
+
 type RowEvent struct {
 	Op        string                 `json:"op"`
 	Timestamp string                 `json:"timestamp"`
@@ -10,10 +16,47 @@ type RowEvent struct {
 	Before    map[string]interface{} `json:"before,omitempty"`
 	Changes   []ColumnChange         `json:"changes,omitempty"`
 	Tombstone bool                   `json:"tombstone,omitempty"`
+	Query     string                 `json:"query,omitempty"`  // originating SQL, when enabled
+	Origin    string                 `json:"origin,omitempty"` // cdc-origin tag of the writing client
+	Heartbeat *Heartbeat             `json:"heartbeat,omitempty"`
+
+	// Latency tracing: when the source committed the transaction, when the
+	// emitter handed the event to the sink (both RFC 3339 UTC), and the W3C
+	// trace context of the emit span for subscribers to continue.
+	CommitTime  string `json:"commit_time,omitempty"`
+	EmitTime    string `json:"emit_time,omitempty"`
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// Heartbeat is set on "heartbeat" events, published each time the emitter
+// reads its own heartbeat row back from the binlog. A subscriber that stops
+// receiving them every IntervalSeconds or so is looking at a stalled
+// pipeline rather than a quiet database.
+type Heartbeat struct {
+	ID              uint32  `json:"id"`
+	WrittenAt       string  `json:"written_at"`  // RFC 3339 UTC, when the row was written
+	ObservedAt      string  `json:"observed_at"` // when the emitter read it from the binlog
+	LagSeconds      float64 `json:"lag_seconds"` // ObservedAt - WrittenAt
+	IntervalSeconds float64 `json:"interval_seconds"`
 }
 
 type ColumnChange struct {
 	Column string      `json:"column"`
 	From   interface{} `json:"from"`
 	To     interface{} `json:"to"`
+
+	// Patch is set for JSON columns logged as partial updates
+	// (binlog_row_value_options=PARTIAL_JSON). Partial is true when the
+	// full new value could not be rebuilt, in which case To is nil and
+	// Patch is the only description of the change.
+	Patch   []JSONPatchOp `json:"patch,omitempty"`
+	Partial bool          `json:"partial,omitempty"`
+}
+
+// JSONPatchOp is a single RFC 6902 style operation ("add", "replace" or
+// "remove") applied to a JSON column. Path is a JSON pointer.
+type JSONPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
 }
@@ -0,0 +1,161 @@
+package logging
+
+// Leveled structured logging (log/slog) configured from the environment
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Attribute keys shared by the emitter and the subscribers.
+const (
+	KeySubscriber = "subscriber"
+	KeyDB         = "db"
+	KeyTable      = "table"
+	KeyOp         = "op"
+	KeyRowKey     = "row_key"
+	KeyBinlogPos  = "binlog_pos"
+	KeyStatus     = "status"
+	KeyDuration   = "duration"
+	KeyError      = "error"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var (
+	// Level is the level of every logger made here. Change it at runtime
+	// with SetLevel, SIGUSR1 (see ToggleDebugOnSignal) or LevelHandler.
+	Level = new(slog.LevelVar)
+
+	configured = slog.LevelInfo
+	format     = FormatText
+	levelToken string // LOG_LEVEL_TOKEN
+)
+
+// Setup makes the default slog logger, and the standard log package which
+// then writes through it, use LOG_LEVEL (debug, info, warn, error; default
+// info) and LOG_FORMAT (text or json; default text), writing to stderr.
+// LOG_LEVEL_TOKEN lets LevelHandler accept changes from other hosts.
+func Setup(get func(string) string) error {
+	levelToken = strings.TrimSpace(get("LOG_LEVEL_TOKEN"))
+	if v := strings.TrimSpace(get("LOG_LEVEL")); v != "" {
+		if err := configured.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL: %q", v)
+		}
+	}
+	switch f := strings.ToLower(strings.TrimSpace(get("LOG_FORMAT"))); f {
+	case "", FormatText:
+		format = FormatText
+	case FormatJSON:
+		format = FormatJSON
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT: %q", f)
+	}
+	Level.Set(configured)
+	// Lines still written through the standard log package become info
+	// records.
+	slog.SetDefault(New(os.Stderr))
+	return nil
+}
+
+// New returns a logger writing to w in the configured format at Level.
+func New(w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: Level}
+	if format == FormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// Err is the attribute for an error.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// SetLevel changes the level of every logger.
+func SetLevel(l slog.Level) {
+	if Level.Level() != l {
+		slog.Info("log level changed", "level", l)
+	}
+	Level.Set(l)
+}
+
+// ToggleDebugOnSignal switches between debug and the configured level each
+// time the process gets SIGUSR1, until ctx is done.
+func ToggleDebugOnSignal(ctx context.Context) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			if Level.Level() == slog.LevelDebug && configured != slog.LevelDebug {
+				SetLevel(configured)
+			} else {
+				SetLevel(slog.LevelDebug)
+			}
+		}
+	}
+}
+
+// LevelHandler reports the level on GET and sets it on PUT or POST, with
+// the level name (debug, info, warn, error) as the request body. Changes
+// are refused with 403 unless mayChangeLevel allows them.
+func LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPost:
+			if !mayChangeLevel(r) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var l slog.Level
+			if err := l.UnmarshalText([]byte(strings.TrimSpace(string(body)))); err != nil {
+				http.Error(w, "unknown level "+strings.TrimSpace(string(body)), http.StatusBadRequest)
+				return
+			}
+			SetLevel(l)
+		default:
+			w.Header().Set("Allow", "GET, PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		fmt.Fprintln(w, Level.Level())
+	})
+}
+
+// mayChangeLevel allows level changes from loopback clients and, when
+// LOG_LEVEL_TOKEN is set, from requests carrying it as a bearer token.
+func mayChangeLevel(r *http.Request) bool {
+	if levelToken != "" {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(auth), []byte(levelToken)) == 1 {
+			return true
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
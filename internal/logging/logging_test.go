@@ -0,0 +1,44 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLevelHandlerAccess(t *testing.T) {
+	env := map[string]string{"LOG_LEVEL_TOKEN": "s3cret"}
+	if err := Setup(func(k string) string { return env[k] }); err != nil {
+		t.Fatal(err)
+	}
+	h := LevelHandler()
+	for _, tc := range []struct {
+		method, remote, auth string
+		want                 int
+	}{
+		{http.MethodGet, "10.0.0.5:4000", "", http.StatusOK},
+		{http.MethodPut, "127.0.0.1:4000", "", http.StatusOK},
+		{http.MethodPut, "[::1]:4000", "", http.StatusOK},
+		{http.MethodPut, "10.0.0.5:4000", "", http.StatusForbidden},
+		{http.MethodPost, "10.0.0.5:4000", "Bearer wrong", http.StatusForbidden},
+		{http.MethodPost, "10.0.0.5:4000", "Bearer s3cret", http.StatusOK},
+	} {
+		Level.Set(slog.LevelInfo)
+		req := httptest.NewRequest(tc.method, "/loglevel", strings.NewReader("debug"))
+		req.RemoteAddr = tc.remote
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s from %s (%q): status %d, want %d", tc.method, tc.remote, tc.auth, rec.Code, tc.want)
+		}
+		changed := Level.Level() == slog.LevelDebug
+		if wantChanged := tc.method != http.MethodGet && tc.want == http.StatusOK; changed != wantChanged {
+			t.Errorf("%s from %s (%q): level changed = %v", tc.method, tc.remote, tc.auth, changed)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package redisconn
+
+// Shared Redis client factory: standalone, Sentinel, Cluster and TLS
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const DefaultAddr = "127.0.0.1:6379"
+
+// Options describes how to reach Redis. The mode is picked in this order:
+// URL, Sentinel (SentinelMaster set), Cluster, then a single node at Addrs[0].
+type Options struct {
+	// URL is a redis:// or rediss:// URL. It replaces Addrs, Username,
+	// Password and DB; with Cluster set it is parsed as a cluster URL.
+	URL string
+
+	Addrs    []string // node address, or cluster seed nodes
+	Username string   // ACL user
+	Password string
+	DB       int
+
+	SentinelMaster   string
+	SentinelAddrs    []string
+	SentinelPassword string
+
+	Cluster bool
+
+	TLS TLSOptions
+}
+
+// TLSOptions enables TLS and optionally sets a private CA and a client
+// certificate. A rediss:// URL enables TLS on its own.
+type TLSOptions struct {
+	Enabled            bool
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// FromEnv reads connection settings:
+//
+//	REDIS_URL, REDIS_ADDR (comma-separated for cluster seeds), REDIS_USERNAME,
+//	REDIS_PASS, REDIS_DB, REDIS_SENTINEL_MASTER, REDIS_SENTINEL_ADDRS,
+//	REDIS_SENTINEL_PASS, REDIS_CLUSTER, REDIS_TLS, REDIS_TLS_CA_FILE,
+//	REDIS_TLS_CERT_FILE, REDIS_TLS_KEY_FILE, REDIS_TLS_SERVER_NAME,
+//	REDIS_TLS_INSECURE_SKIP_VERIFY
+func FromEnv(get func(string) string) (Options, error) {
+	o := Options{
+		URL:              strings.TrimSpace(get("REDIS_URL")),
+		Addrs:            splitCSV(get("REDIS_ADDR")),
+		Username:         get("REDIS_USERNAME"),
+		Password:         get("REDIS_PASS"),
+		SentinelMaster:   strings.TrimSpace(get("REDIS_SENTINEL_MASTER")),
+		SentinelAddrs:    splitCSV(get("REDIS_SENTINEL_ADDRS")),
+		SentinelPassword: get("REDIS_SENTINEL_PASS"),
+		TLS: TLSOptions{
+			CAFile:     get("REDIS_TLS_CA_FILE"),
+			CertFile:   get("REDIS_TLS_CERT_FILE"),
+			KeyFile:    get("REDIS_TLS_KEY_FILE"),
+			ServerName: get("REDIS_TLS_SERVER_NAME"),
+		},
+	}
+
+	if v := strings.TrimSpace(get("REDIS_DB")); v != "" {
+		db, err := strconv.Atoi(v)
+		if err != nil {
+			return o, fmt.Errorf("invalid REDIS_DB: %w", err)
+		}
+		o.DB = db
+	}
+	for _, b := range []struct {
+		env string
+		dst *bool
+	}{
+		{"REDIS_CLUSTER", &o.Cluster},
+		{"REDIS_TLS", &o.TLS.Enabled},
+		{"REDIS_TLS_INSECURE_SKIP_VERIFY", &o.TLS.InsecureSkipVerify},
+	} {
+		if v := strings.TrimSpace(get(b.env)); v != "" {
+			val, err := strconv.ParseBool(v)
+			if err != nil {
+				return o, fmt.Errorf("invalid %s: %q", b.env, v)
+			}
+			*b.dst = val
+		}
+	}
+
+	if o.SentinelMaster != "" && len(o.SentinelAddrs) == 0 {
+		return o, fmt.Errorf("REDIS_SENTINEL_MASTER needs REDIS_SENTINEL_ADDRS")
+	}
+	if o.SentinelMaster != "" && o.Cluster {
+		return o, fmt.Errorf("REDIS_SENTINEL_MASTER and REDIS_CLUSTER are mutually exclusive")
+	}
+	if o.Cluster && o.DB != 0 {
+		return o, fmt.Errorf("REDIS_DB must be 0 with REDIS_CLUSTER")
+	}
+	return o, nil
+}
+
+// NewClient connects according to o. The client is lazy: connection errors
+// surface on first use.
+func (o Options) NewClient() (redis.UniversalClient, error) {
+	switch {
+	case o.URL != "" && o.Cluster:
+		co, err := redis.ParseClusterURL(o.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parse REDIS_URL: %w", err)
+		}
+		if co.TLSConfig, err = o.TLS.config(co.TLSConfig); err != nil {
+			return nil, err
+		}
+		return redis.NewClusterClient(co), nil
+
+	case o.URL != "":
+		ro, err := redis.ParseURL(o.URL)
+		if err != nil {
+			return nil, fmt.Errorf("parse REDIS_URL: %w", err)
+		}
+		if ro.TLSConfig, err = o.TLS.config(ro.TLSConfig); err != nil {
+			return nil, err
+		}
+		return redis.NewClient(ro), nil
+	}
+
+	tlsCfg, err := o.TLS.config(nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case o.SentinelMaster != "":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       o.SentinelMaster,
+			SentinelAddrs:    o.SentinelAddrs,
+			SentinelPassword: o.SentinelPassword,
+			Username:         o.Username,
+			Password:         o.Password,
+			DB:               o.DB,
+			TLSConfig:        tlsCfg,
+		}), nil
+
+	case o.Cluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     o.addrs(),
+			Username:  o.Username,
+			Password:  o.Password,
+			TLSConfig: tlsCfg,
+		}), nil
+
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:      o.addrs()[0],
+			Username:  o.Username,
+			Password:  o.Password,
+			DB:        o.DB,
+			TLSConfig: tlsCfg,
+		}), nil
+	}
+}
+
+func (o Options) addrs() []string {
+	if len(o.Addrs) == 0 {
+		return []string{DefaultAddr}
+	}
+	return o.Addrs
+}
+
+// String describes the connection for logs, without credentials.
+func (o Options) String() string {
+	var s string
+	switch {
+	case o.URL != "":
+		s = redactURL(o.URL)
+		if o.Cluster {
+			s = "cluster " + s
+		}
+	case o.SentinelMaster != "":
+		s = fmt.Sprintf("sentinel master=%s sentinels=%s db=%d", o.SentinelMaster, strings.Join(o.SentinelAddrs, ","), o.DB)
+	case o.Cluster:
+		s = "cluster " + strings.Join(o.addrs(), ",")
+	default:
+		s = fmt.Sprintf("%s db=%d", o.addrs()[0], o.DB)
+	}
+	if o.TLS.Enabled && !strings.HasPrefix(o.URL, "rediss://") {
+		s += " tls"
+	}
+	return s
+}
+
+// config builds the TLS config: base (from a rediss:// URL) or a new one
+// when TLS is enabled, with the configured CA and client certificate.
+func (t TLSOptions) config(base *tls.Config) (*tls.Config, error) {
+	if base == nil && !t.Enabled {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		cfg = base.Clone()
+	}
+	if t.ServerName != "" {
+		cfg.ServerName = t.ServerName
+	}
+	cfg.InsecureSkipVerify = t.InsecureSkipVerify
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read REDIS_TLS_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("REDIS_TLS_CA_FILE %s: no certificates found", t.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load Redis client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func redactURL(s string) string {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return s
+	}
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		rest = rest[at+1:]
+	}
+	return scheme + "://" + rest
+}
+
+func splitCSV(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
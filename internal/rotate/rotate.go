@@ -0,0 +1,350 @@
+package rotate
+
+// Size- and time-based rotating file writer with compression and retention
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"mysql_changelog_publisher/internal/logging"
+)
+
+const (
+	backupTimeFormat = "20060102-150405.000"
+	gzipSuffix       = ".gz"
+)
+
+// Options are the rotation and retention limits of a Writer. Zero values
+// disable the respective limit.
+type Options struct {
+	// MaxBytes rotates the file once a write would grow it past this size.
+	MaxBytes int64
+	// MaxFiles keeps only the newest rotated files.
+	MaxFiles int
+	// MaxAge removes rotated files older than this.
+	MaxAge time.Duration
+	// Interval rotates the file at every multiple of Interval since the
+	// zero time, so 24h rotates at midnight UTC.
+	Interval time.Duration
+	// Compress gzips rotated files in the background.
+	Compress bool
+}
+
+// OptionsFromEnv reads prefix+MAX_BYTES, MAX_FILES, MAX_AGE, ROTATE_INTERVAL
+// and COMPRESS through get, starting from def.
+func OptionsFromEnv(prefix string, get func(string) string, def Options) (Options, error) {
+	o := def
+	if v := strings.TrimSpace(get(prefix + "MAX_BYTES")); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("invalid %sMAX_BYTES: %q", prefix, v)
+		}
+		o.MaxBytes = n
+	}
+	if v := strings.TrimSpace(get(prefix + "MAX_FILES")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return o, fmt.Errorf("invalid %sMAX_FILES: %q", prefix, v)
+		}
+		o.MaxFiles = n
+	}
+	for _, d := range []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"MAX_AGE", &o.MaxAge},
+		{"ROTATE_INTERVAL", &o.Interval},
+	} {
+		if v := strings.TrimSpace(get(prefix + d.env)); v != "" {
+			n, err := time.ParseDuration(v)
+			if err != nil || n < 0 {
+				return o, fmt.Errorf("invalid %s%s: %q", prefix, d.env, v)
+			}
+			*d.dst = n
+		}
+	}
+	if v := strings.TrimSpace(get(prefix + "COMPRESS")); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return o, fmt.Errorf("invalid %sCOMPRESS: %q", prefix, v)
+		}
+		o.Compress = b
+	}
+	return o, nil
+}
+
+// Writer appends to Path and rotates it once it would grow past MaxBytes or
+// when an Interval boundary passes. Rotated files are renamed to
+// Path.<timestamp> (Path.<timestamp>.gz when compressed); only the newest
+// MaxFiles of them, none older than MaxAge, are kept.
+type Writer struct {
+	Path string
+	Options
+
+	mu     sync.Mutex
+	file   *os.File // nil after a failed rotation or reopen, until reopened
+	size   int64
+	next   time.Time // next Interval boundary
+	closed bool
+
+	bg     sync.Mutex // serializes compression and pruning
+	bgDone sync.WaitGroup
+}
+
+// Open opens path with size-based rotation only.
+func Open(path string, maxBytes int64, maxFiles int) (*Writer, error) {
+	return OpenWith(path, Options{MaxBytes: maxBytes, MaxFiles: maxFiles})
+}
+
+func OpenWith(path string, o Options) (*Writer, error) {
+	w := &Writer{Path: path, Options: o}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Writer) open() error {
+	if dir := filepath.Dir(w.Path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(w.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	if w.Interval > 0 {
+		// A file left over from an earlier period rotates on the first write.
+		since := time.Now()
+		if w.size > 0 {
+			since = info.ModTime()
+		}
+		w.next = since.Truncate(w.Interval).Add(w.Interval)
+	}
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.file == nil {
+		// An earlier rotation or reopen could not open Path; try again.
+		if err := w.open(); err != nil {
+			return 0, fmt.Errorf("reopen %s: %w", w.Path, err)
+		}
+	}
+	full := w.MaxBytes > 0 && w.size+int64(len(p)) > w.MaxBytes
+	due := w.Interval > 0 && !time.Now().Before(w.next)
+	if w.size > 0 && (full || due) {
+		if err := w.rotate(); err != nil {
+			return 0, fmt.Errorf("rotate %s: %w", w.Path, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens Path, for after an external tool such as
+// logrotate moved the file away. When opening fails, the next Write tries
+// again.
+func (w *Writer) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+		if err != nil {
+			return err
+		}
+	}
+	return w.open()
+}
+
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	backup := w.Path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(w.Path, backup); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	if !w.Compress {
+		return w.prune()
+	}
+	w.bgDone.Add(1)
+	go func() {
+		defer w.bgDone.Done()
+		if err := w.compressAndPrune(); err != nil {
+			slog.Warn("compress rotated files", "path", w.Path, logging.Err(err))
+		}
+	}()
+	return nil
+}
+
+// compressAndPrune gzips every uncompressed backup, including any left by a
+// previous run, then prunes.
+func (w *Writer) compressAndPrune() error {
+	w.bg.Lock()
+	defer w.bg.Unlock()
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		if !strings.HasSuffix(b, gzipSuffix) {
+			if err := compress(b); err != nil {
+				return err
+			}
+		}
+	}
+	return w.prune()
+}
+
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := path + gzipSuffix + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+gzipSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// prune removes the oldest backups beyond MaxFiles and those older than
+// MaxAge.
+func (w *Writer) prune() error {
+	if w.MaxFiles <= 0 && w.MaxAge <= 0 {
+		return nil
+	}
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-w.MaxAge)
+	for i, b := range backups {
+		tooMany := w.MaxFiles > 0 && len(backups)-i > w.MaxFiles
+		tooOld := w.MaxAge > 0 && w.rotatedAt(b).Before(cutoff)
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotatedAt parses the timestamp of a backup name, or returns the zero time.
+func (w *Writer) rotatedAt(backup string) time.Time {
+	s := strings.TrimSuffix(strings.TrimPrefix(backup, w.Path+"."), gzipSuffix)
+	t, err := time.ParseInLocation(backupTimeFormat, s, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// backups lists rotated files, compressed or not, oldest first.
+func (w *Writer) backups() ([]string, error) {
+	matches, err := filepath.Glob(w.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	out := matches[:0]
+	for _, m := range matches {
+		if !w.rotatedAt(m).IsZero() {
+			out = append(out, m)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Close closes the file and waits for background compression to finish.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+	w.bgDone.Wait()
+	return err
+}
+
+// ReopenOnSignal reopens ws each time the process gets SIGHUP, until ctx is
+// done, so external log rotation can move the files away.
+func ReopenOnSignal(ctx context.Context, ws ...*Writer) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			for _, w := range ws {
+				if w == nil {
+					continue
+				}
+				if err := w.Reopen(); err != nil {
+					slog.Error("reopen log file", "path", w.Path, logging.Err(err))
+					continue
+				}
+				slog.Info("log file reopened", "path", w.Path)
+			}
+		}
+	}
+}
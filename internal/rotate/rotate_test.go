@@ -0,0 +1,76 @@
+package rotate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteRetriesAfterFailedReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Something else now sits at path, so reopening fails.
+	if err := os.Rename(path, path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Reopen(); err == nil {
+		t.Fatal("Reopen onto a directory succeeded")
+	}
+	if _, err := w.Write([]byte("lost\n")); err == nil {
+		t.Fatal("Write without a file succeeded")
+	}
+
+	// Once path is usable again, writes resume without another Reopen.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("two\n")); err != nil {
+		t.Fatalf("Write after the path recovered: %v", err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "two\n" {
+		t.Errorf("file holds %q", got)
+	}
+
+	w.Close()
+	if _, err := w.Write([]byte("closed\n")); err == nil {
+		t.Error("Write after Close succeeded")
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := Open(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := os.ReadFile(path); string(got) != "cccccccc\n" {
+		t.Errorf("current file holds %q", got)
+	}
+	backups, err := w.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("backups %v, want only the newest", backups)
+	}
+	if got, _ := os.ReadFile(backups[0]); string(got) != "bbbbbbbb\n" {
+		t.Errorf("backup holds %q", got)
+	}
+}
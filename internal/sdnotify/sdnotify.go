@@ -0,0 +1,59 @@
+package sdnotify
+
+// systemd notification protocol (sd_notify) over NOTIFY_SOCKET
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Reloading = "RELOADING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Notify sends state to the socket in NOTIFY_SOCKET. It reports false, with
+// no error, when not running under systemd.
+func Notify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	return true, NotifySocket(path, state)
+}
+
+// NotifySocket sends state to the unix datagram socket at path. A leading
+// '@' selects the abstract namespace.
+func NotifySocket(path, state string) error {
+	if path[0] == '@' {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Status formats a STATUS= line.
+func Status(s string) string {
+	return "STATUS=" + s
+}
+
+// WatchdogInterval returns how often systemd expects WATCHDOG=1, from
+// WATCHDOG_USEC, or 0 when the watchdog is off or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
@@ -0,0 +1,90 @@
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, name string) *net.UnixConn {
+	t.Helper()
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn := listen(t, path)
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	for _, state := range []string{Ready, Watchdog, Status("draining"), Stopping} {
+		ok, err := Notify(state)
+		if !ok || err != nil {
+			t.Fatalf("Notify(%q) = %v, %v", state, ok, err)
+		}
+		if got := receive(t, conn); got != state {
+			t.Errorf("datagram %q, want %q", got, state)
+		}
+	}
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	// Go maps a leading '@' to the abstract namespace on both ends.
+	name := fmt.Sprintf("@sdnotify-test-%d", os.Getpid())
+	conn := listen(t, name)
+	t.Setenv("NOTIFY_SOCKET", name)
+
+	if ok, err := Notify(Ready); !ok || err != nil {
+		t.Fatalf("Notify = %v, %v", ok, err)
+	}
+	if got := receive(t, conn); got != Ready {
+		t.Errorf("datagram %q, want %q", got, Ready)
+	}
+}
+
+func TestNotifyWithoutSystemd(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if ok, err := Notify(Ready); ok || err != nil {
+		t.Errorf("Notify = %v, %v; want false, nil", ok, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, tc := range []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"0", "", 0},
+		{"bad", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", pid, 30 * time.Second},
+		{"30000000", "1", 0},
+	} {
+		t.Setenv("WATCHDOG_USEC", tc.usec)
+		t.Setenv("WATCHDOG_PID", tc.pid)
+		if got := WatchdogInterval(); got != tc.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: %s, want %s", tc.usec, tc.pid, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"mysql_changelog_publisher/internal/rotate"
+)
+
+// Writer writes one payload per line (JSONL) to an io.Writer.
+type Writer struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+	c    io.Closer
+}
+
+// NewFile returns a JSONL sink that appends to path, rotating it once it
+// would exceed maxBytes and keeping maxFiles rotated files.
+func NewFile(path string, maxBytes int64, maxFiles int) (*Writer, error) {
+	rw, err := rotate.Open(path, maxBytes, maxFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{name: "file", w: rw, c: rw}, nil
+}
+
+// NewStdout returns a JSONL sink that writes to standard output.
+func NewStdout() *Writer {
+	return &Writer{name: "stdout", w: os.Stdout}
+}
+
+func (s *Writer) Name() string { return s.name }
+
+func (s *Writer) Publish(ctx context.Context, msgs []Message) []error {
+	errs := make([]error, len(msgs))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// One Write per line so rotation never splits a payload.
+	for i, m := range msgs {
+		line := make([]byte, 0, len(m.Payload)+1)
+		line = append(append(line, m.Payload...), '\n')
+		if _, err := s.w.Write(line); err != nil {
+			errs[i] = err
+		}
+	}
+	return errs
+}
+
+func (s *Writer) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
@@ -0,0 +1,176 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// mqttConnectTimeout bounds the wait for the first connection at startup.
+	mqttConnectTimeout = 10 * time.Second
+	// mqttPublishTimeout bounds the wait for the broker to acknowledge a batch.
+	mqttPublishTimeout = 10 * time.Second
+)
+
+// MQTTOptions configures NewMQTT. Topic templates may use {db}, {table},
+// {op} and {row_key}.
+type MQTTOptions struct {
+	Broker   string // e.g. tcp://127.0.0.1:1883
+	ClientID string
+	Username string
+	Password string
+	QoS      byte
+
+	Topic string
+	// RetainTopic, when set, also receives a retained copy of each event so
+	// new dashboard clients see the last value per row. A delete clears it.
+	RetainTopic string
+	// Tables limits publishing to these tables ("table" or "db.table");
+	// empty means all tables.
+	Tables []string
+}
+
+// MQTT publishes messages to an MQTT broker on topics built from the event.
+type MQTT struct {
+	client mqtt.Client
+	opts   MQTTOptions
+	tables map[string]struct{}
+}
+
+func NewMQTT(o MQTTOptions) (*MQTT, error) {
+	co := mqtt.NewClientOptions().
+		AddBroker(o.Broker).
+		SetClientID(o.ClientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second)
+	if o.Username != "" {
+		co.SetUsername(o.Username)
+		co.SetPassword(o.Password)
+	}
+	client := mqtt.NewClient(co)
+	// With ConnectRetry the token only completes once connected, so don't
+	// hold up startup for long; the client keeps retrying in the background
+	// and publishes fail (and spool) until it is up.
+	t := client.Connect()
+	if !t.WaitTimeout(mqttConnectTimeout) {
+		slog.Warn("MQTT broker not reachable yet; retrying in the background", "broker", o.Broker, "waited", mqttConnectTimeout)
+	} else if err := t.Error(); err != nil {
+		return nil, fmt.Errorf("mqtt connect %s: %w", o.Broker, err)
+	}
+	return NewMQTTClient(client, o), nil
+}
+
+// NewMQTTClient is NewMQTT over an existing client, e.g. one connected to
+// an embedded broker. The sink takes ownership of client.
+func NewMQTTClient(client mqtt.Client, o MQTTOptions) *MQTT {
+	m := &MQTT{client: client, opts: o}
+	if len(o.Tables) > 0 {
+		m.tables = make(map[string]struct{}, len(o.Tables))
+		for _, t := range o.Tables {
+			m.tables[strings.ToLower(t)] = struct{}{}
+		}
+	}
+	return m
+}
+
+func (m *MQTT) Name() string { return "mqtt" }
+
+func (m *MQTT) selected(msg Message) bool {
+	if m.tables == nil {
+		return true
+	}
+	if _, ok := m.tables[strings.ToLower(msg.Table)]; ok {
+		return true
+	}
+	_, ok := m.tables[strings.ToLower(msg.DB+"."+msg.Table)]
+	return ok
+}
+
+// Publish fails every selected message while the client is disconnected:
+// paho would drop QoS 0 messages silently and hold QoS 1 and 2 ones until it
+// reconnects. Returning an error lets the caller spool them instead. The
+// wait for acknowledgements ends when ctx is done.
+func (m *MQTT) Publish(ctx context.Context, msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	tokens := make([][]mqtt.Token, len(msgs))
+	connected := m.client.IsConnectionOpen()
+	for i, msg := range msgs {
+		if !m.selected(msg) {
+			continue
+		}
+		if !connected {
+			errs[i] = errors.New("mqtt: not connected")
+			continue
+		}
+		tokens[i] = append(tokens[i], m.client.Publish(MQTTTopic(m.opts.Topic, msg), m.opts.QoS, false, msg.Payload))
+		if m.opts.RetainTopic != "" {
+			var retained []byte
+			if msg.Op != "delete" {
+				retained = msg.Payload
+			}
+			tokens[i] = append(tokens[i], m.client.Publish(MQTTTopic(m.opts.RetainTopic, msg), m.opts.QoS, true, retained))
+		}
+	}
+	wait, cancel := context.WithTimeout(ctx, mqttPublishTimeout)
+	defer cancel()
+	for i, ts := range tokens {
+		for _, t := range ts {
+			select {
+			case <-t.Done():
+				if err := t.Error(); err != nil {
+					errs[i] = errors.Join(errs[i], err)
+				}
+			case <-wait.Done():
+				err := errors.New("mqtt: publish not acknowledged in time")
+				if ctx.Err() != nil {
+					err = fmt.Errorf("mqtt: publish not acknowledged: %w", ctx.Err())
+				}
+				errs[i] = errors.Join(errs[i], err)
+			}
+		}
+	}
+	return errs
+}
+
+func (m *MQTT) Ping(ctx context.Context) error {
+	if !m.client.IsConnectionOpen() {
+		return errors.New("not connected")
+	}
+	return nil
+}
+
+func (m *MQTT) Close() error {
+	m.client.Disconnect(250)
+	return nil
+}
+
+// MQTTTopic expands a topic template for msg. Characters with a meaning in
+// MQTT topics ('/', '+', '#') are replaced with '_' in the substituted values.
+func MQTTTopic(tmpl string, msg Message) string {
+	return strings.NewReplacer(
+		"{db}", topicLevel(msg.DB),
+		"{table}", topicLevel(msg.Table),
+		"{op}", topicLevel(msg.Op),
+		"{row_key}", topicLevel(msg.RowKey),
+	).Replace(tmpl)
+}
+
+func topicLevel(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '+', '#', 0:
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,165 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+type received struct {
+	topic   string
+	payload string
+	retain  bool
+}
+
+// runBroker starts an embedded MQTT broker and returns a func that stops it,
+// its address and the messages it receives on #.
+func runBroker(t *testing.T) (func(), string, <-chan received) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	b := mochi.New(&mochi.Options{InlineClient: true, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := b.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	stop := func() { once.Do(func() { b.Close() }) }
+	t.Cleanup(stop)
+
+	ch := make(chan received, 16)
+	err = b.Subscribe("#", 1, func(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+		ch <- received{topic: pk.TopicName, payload: string(pk.Payload), retain: pk.FixedHeader.Retain}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stop, "tcp://" + addr, ch
+}
+
+func newTestMQTT(t *testing.T, broker string, o MQTTOptions) *MQTT {
+	t.Helper()
+	co := mqtt.NewClientOptions().AddBroker(broker).SetClientID("sink-test").SetAutoReconnect(true)
+	client := mqtt.NewClient(co)
+	if tok := client.Connect(); !tok.WaitTimeout(5*time.Second) || tok.Error() != nil {
+		t.Fatalf("connect: %v", tok.Error())
+	}
+	m := NewMQTTClient(client, o)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func expect(t *testing.T, ch <-chan received, want received) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != want {
+			t.Errorf("broker got %+v, want %+v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("broker did not receive %+v", want)
+	}
+}
+
+func TestMQTTPublish(t *testing.T) {
+	_, broker, ch := runBroker(t)
+	m := newTestMQTT(t, broker, MQTTOptions{
+		QoS:         1,
+		Topic:       "cdc/{db}/{table}/{op}",
+		RetainTopic: "state/{db}/{table}/{row_key}",
+		Tables:      []string{"crm.leads"},
+	})
+
+	msgs := []Message{
+		{DB: "crm", Table: "leads", Op: "create", RowKey: "a/1", Payload: []byte(`{"n":1}`)},
+		{DB: "crm", Table: "orders", Op: "create", RowKey: "2", Payload: []byte(`{"n":2}`)},
+	}
+	for i, err := range m.Publish(context.Background(), msgs) {
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	expect(t, ch, received{topic: "cdc/crm/leads/create", payload: `{"n":1}`})
+	expect(t, ch, received{topic: "state/crm/leads/a_1", payload: `{"n":1}`, retain: true})
+	select {
+	case got := <-ch:
+		t.Errorf("unselected table published: %+v", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestMQTTPublishDisconnected(t *testing.T) {
+	for _, qos := range []byte{0, 1} {
+		stop, broker, _ := runBroker(t)
+		m := newTestMQTT(t, broker, MQTTOptions{QoS: qos, Topic: "cdc/{table}"})
+		stop()
+		deadline := time.Now().Add(5 * time.Second)
+		for m.client.IsConnectionOpen() && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		start := time.Now()
+		errs := m.Publish(context.Background(), []Message{{DB: "crm", Table: "leads", Op: "create", Payload: []byte("{}")}})
+		if errs[0] == nil {
+			t.Errorf("QoS %d: publish while disconnected succeeded", qos)
+		}
+		if took := time.Since(start); took > time.Second {
+			t.Errorf("QoS %d: publish while disconnected took %s", qos, took)
+		}
+	}
+}
+
+// stalledClient accepts publishes that the broker never acknowledges.
+type stalledClient struct {
+	mqtt.Client
+}
+
+func (stalledClient) IsConnectionOpen() bool { return true }
+func (stalledClient) Disconnect(uint)        {}
+
+func (stalledClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	return pendingToken{}
+}
+
+type pendingToken struct{}
+
+func (pendingToken) Wait() bool                     { select {} }
+func (pendingToken) WaitTimeout(time.Duration) bool { return false }
+func (pendingToken) Done() <-chan struct{}          { return nil }
+func (pendingToken) Error() error                   { return nil }
+
+func TestMQTTPublishCancelled(t *testing.T) {
+	m := NewMQTTClient(stalledClient{}, MQTTOptions{QoS: 1, Topic: "cdc/{table}"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	errs := m.Publish(ctx, []Message{{DB: "crm", Table: "leads", Op: "create", Payload: []byte("{}")}})
+	if !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("publish error = %v, want the context's", errs[0])
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("publish kept waiting %s after ctx was done", took)
+	}
+}
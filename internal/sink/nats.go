@@ -0,0 +1,125 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATS publishes messages to JetStream on subjects of the form
+// <prefix>.<db>.<table>.<op>, waiting for the stream to acknowledge each one.
+type NATS struct {
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	prefix string
+}
+
+// NATSOptions configures NewNATS. When Stream is set, a stream capturing
+// <Prefix>.> is created if it does not exist yet.
+type NATSOptions struct {
+	URL       string
+	CredsFile string
+	Prefix    string
+	Stream    string
+}
+
+func NewNATS(ctx context.Context, o NATSOptions) (*NATS, error) {
+	var opts []nats.Option
+	opts = append(opts, nats.Name("binlog-emitter"), nats.MaxReconnects(-1))
+	if o.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(o.CredsFile))
+	}
+	nc, err := nats.Connect(o.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("nats connect %s: %w", o.URL, err)
+	}
+	s, err := NewNATSConn(ctx, nc, o.Prefix, o.Stream)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewNATSConn is NewNATS over an existing connection, e.g. one to an
+// embedded server. The sink takes ownership of nc.
+func NewNATSConn(ctx context.Context, nc *nats.Conn, prefix, stream string) (*NATS, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("jetstream: %w", err)
+	}
+	if stream != "" {
+		_, err := js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     stream,
+			Subjects: []string{prefix + ".>"},
+		})
+		if err != nil && !errors.Is(err, jetstream.ErrStreamNameAlreadyInUse) {
+			return nil, fmt.Errorf("create stream %s: %w", stream, err)
+		}
+	}
+	return &NATS{nc: nc, js: js, prefix: prefix}, nil
+}
+
+func (n *NATS) Name() string { return "nats" }
+
+func (n *NATS) Publish(ctx context.Context, msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	futures := make([]jetstream.PubAckFuture, len(msgs))
+	for i, m := range msgs {
+		msg := nats.NewMsg(Subject(n.prefix, m.DB, m.Table, m.Op))
+		msg.Header.Set("Event-Id", m.ID)
+		msg.Data = m.Payload
+		futures[i], errs[i] = n.js.PublishMsgAsync(msg)
+	}
+	for i, f := range futures {
+		if f == nil {
+			continue
+		}
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			errs[i] = err
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+		}
+	}
+	return errs
+}
+
+func (n *NATS) Ping(ctx context.Context) error {
+	if st := n.nc.Status(); st != nats.CONNECTED {
+		return fmt.Errorf("connection %s", st)
+	}
+	return nil
+}
+
+func (n *NATS) Close() error {
+	if err := n.nc.Drain(); err != nil {
+		n.nc.Close()
+		return err
+	}
+	return nil
+}
+
+// Subject returns the subject an event is published on. Characters NATS
+// treats specially in a subject token are replaced with '_'.
+func Subject(prefix, db, table, op string) string {
+	return strings.Join([]string{prefix, subjectToken(db), subjectToken(table), subjectToken(op)}, ".")
+}
+
+func subjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func runJetStream(t *testing.T) *server.Server {
+	t.Helper()
+	opts := test.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	s := test.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connect(t *testing.T, s *server.Server) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestNATSPublish(t *testing.T) {
+	s := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	n, err := NewNATSConn(ctx, connect(t, s), "binlog", "BINLOG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	msgs := []Message{
+		{ID: "crm.leads:create:1", DB: "crm", Table: "leads", Op: "create", Payload: []byte(`{"n":1}`)},
+		{ID: "crm.x.y:delete:2", DB: "crm", Table: "x.y", Op: "delete", Payload: []byte(`{"n":2}`)},
+	}
+	for i, err := range n.Publish(ctx, msgs) {
+		if err != nil {
+			t.Fatalf("message %d not acked: %v", i, err)
+		}
+	}
+
+	js, err := jetstream.New(connect(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := js.Stream(ctx, "BINLOG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"binlog.crm.leads.create", "binlog.crm.x_y.delete"} {
+		got, err := stream.GetMsg(ctx, uint64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Subject != want {
+			t.Errorf("message %d subject = %q, want %q", i, got.Subject, want)
+		}
+		if id := got.Header.Get("Event-Id"); id != msgs[i].ID {
+			t.Errorf("message %d Event-Id = %q, want %q", i, id, msgs[i].ID)
+		}
+		if string(got.Data) != string(msgs[i].Payload) {
+			t.Errorf("message %d data = %s", i, got.Data)
+		}
+	}
+}
+
+func TestNATSPublishWithoutStream(t *testing.T) {
+	s := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// No stream captures these subjects, so JetStream never acks.
+	n, err := NewNATSConn(ctx, connect(t, s), "nowhere", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	errs := n.Publish(ctx, []Message{{ID: "a", DB: "crm", Table: "leads", Op: "create", Payload: []byte("{}")}})
+	if errs[0] == nil {
+		t.Fatal("publish without a stream succeeded")
+	}
+}
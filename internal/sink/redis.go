@@ -0,0 +1,54 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis publishes messages to a pub/sub channel, pipelining each batch into
+// a single round trip.
+type Redis struct {
+	name    string
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewRedis returns a sink called name publishing to channel.
+func NewRedis(name string, client redis.UniversalClient, channel string) *Redis {
+	return &Redis{name: name, client: client, channel: channel}
+}
+
+func (r *Redis) Name() string { return r.name }
+
+func (r *Redis) Publish(ctx context.Context, msgs []Message) []error {
+	errs := make([]error, len(msgs))
+	if len(msgs) == 0 {
+		return errs
+	}
+	cmds, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, m := range msgs {
+			pipe.Publish(ctx, r.channel, string(m.Payload))
+		}
+		return nil
+	})
+	if len(cmds) != len(msgs) {
+		if err == nil {
+			err = fmt.Errorf("redis pipeline returned %d replies for %d messages", len(cmds), len(msgs))
+		}
+		return fill(errs, err)
+	}
+	for i, cmd := range cmds {
+		errs[i] = cmd.Err()
+	}
+	return errs
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
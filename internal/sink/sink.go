@@ -0,0 +1,301 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is one event ready to leave the emitter. Payload is the wire form
+// (possibly encrypted and signed); the other fields describe the event so
+// sinks can route it without decoding the payload.
+type Message struct {
+	ID      string // db.table:kind:row_key
+	DB      string
+	Table   string
+	Op      string
+	RowKey  string
+	Payload []byte
+	// Targets, when set, limits Multi to the sinks with these names, e.g.
+	// the ones a spooled message has not reached yet.
+	Targets []string
+}
+
+// Sink delivers messages to a destination. Publish returns one error per
+// message, in order; nil means the message was delivered.
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, msgs []Message) []error
+	Close() error
+}
+
+// Policy decides when Multi considers a message delivered.
+type Policy int
+
+const (
+	// All requires every sink to accept the message.
+	All Policy = iota
+	// BestEffort requires at least one sink to accept the message; failures
+	// on the others are only reflected in their health.
+	BestEffort
+)
+
+// ParsePolicy accepts "all" and "best-effort" (or "best_effort").
+func ParsePolicy(s string) (Policy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "all":
+		return All, nil
+	case "best-effort", "best_effort", "besteffort":
+		return BestEffort, nil
+	default:
+		return All, fmt.Errorf("unknown delivery policy %q (want all or best-effort)", s)
+	}
+}
+
+// TargetsError is returned by Multi for a message some of its sinks failed
+// to deliver. Failed names those sinks, so a retry can skip the others; for
+// a nested Multi it lists the failed sinks inside it.
+type TargetsError struct {
+	Failed []string
+	Err    error
+}
+
+func (e *TargetsError) Error() string { return e.Err.Error() }
+func (e *TargetsError) Unwrap() error { return e.Err }
+
+// Pinger is implemented by sinks that can check their connection.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthReporter is implemented by sinks that track the health of the sinks
+// they wrap.
+type HealthReporter interface {
+	Health() []Health
+}
+
+// Health describes one sink as seen by Multi.
+type Health struct {
+	Name        string
+	Healthy     bool
+	LastError   string
+	LastSuccess time.Time
+	Failures    int64 // consecutive failed publishes
+}
+
+// Multi fans every message out to all of its sinks and tracks each sink's
+// health. Which failures count against a message depends on the policy.
+type Multi struct {
+	sinks  []Sink
+	policy Policy
+
+	mu     sync.Mutex
+	health []Health
+}
+
+func NewMulti(policy Policy, sinks ...Sink) *Multi {
+	m := &Multi{sinks: sinks, policy: policy, health: make([]Health, len(sinks))}
+	for i, s := range sinks {
+		m.health[i] = Health{Name: s.Name(), Healthy: true}
+	}
+	return m
+}
+
+func (m *Multi) Name() string {
+	name := "multi("
+	for i, s := range m.sinks {
+		if i > 0 {
+			name += ","
+		}
+		name += s.Name()
+	}
+	if m.policy == BestEffort {
+		return name + ";best-effort)"
+	}
+	return name + ")"
+}
+
+// Publish sends each message to every sink, or to those its Targets name.
+// Under the All policy a failed message gets a *TargetsError naming the
+// sinks that still need it.
+func (m *Multi) Publish(ctx context.Context, msgs []Message) []error {
+	// results[i][j] is sink i's error for msgs[j]; sent[i][j] whether it
+	// was sent there at all.
+	results := make([][]error, len(m.sinks))
+	sent := make([][]bool, len(m.sinks))
+	var wg sync.WaitGroup
+	for i, s := range m.sinks {
+		results[i] = make([]error, len(msgs))
+		sent[i] = make([]bool, len(msgs))
+		var batch []Message
+		var idx []int
+		for j, msg := range msgs {
+			if targets(msg, s) {
+				sent[i][j] = true
+				batch = append(batch, msg)
+				idx = append(idx, j)
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := s.Publish(ctx, batch)
+			for k, j := range idx {
+				results[i][j] = res[k]
+			}
+			m.record(i, res)
+		}()
+	}
+	wg.Wait()
+
+	errs := make([]error, len(msgs))
+	for j := range msgs {
+		delivered := 0
+		var failed error
+		var names []string
+		for i, s := range m.sinks {
+			if !sent[i][j] {
+				continue
+			}
+			if err := results[i][j]; err != nil {
+				failed = errors.Join(failed, fmt.Errorf("%s: %w", s.Name(), err))
+				var te *TargetsError
+				if _, nested := s.(*Multi); nested && errors.As(err, &te) {
+					names = append(names, te.Failed...)
+				} else {
+					names = append(names, s.Name())
+				}
+			} else {
+				delivered++
+			}
+		}
+		switch {
+		case failed == nil:
+		case m.policy == BestEffort && delivered > 0:
+		default:
+			errs[j] = &TargetsError{Failed: names, Err: failed}
+		}
+	}
+	return errs
+}
+
+// targets reports whether msg is to be sent to s: a nested Multi when it
+// holds a targeted sink.
+func targets(msg Message, s Sink) bool {
+	if len(msg.Targets) == 0 {
+		return true
+	}
+	if m, ok := s.(*Multi); ok {
+		for _, inner := range m.sinks {
+			if targets(msg, inner) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, t := range msg.Targets {
+		if t == s.Name() {
+			return true
+		}
+	}
+	return false
+}
+
+// Leaves returns the names Message.Targets and TargetsError use for the
+// sinks s delivers to: its own name, or those of the sinks inside a Multi.
+func Leaves(s Sink) []string {
+	m, ok := s.(*Multi)
+	if !ok {
+		return []string{s.Name()}
+	}
+	var names []string
+	for _, inner := range m.sinks {
+		names = append(names, Leaves(inner)...)
+	}
+	return names
+}
+
+// record updates sink i's health from one batch, logging transitions.
+func (m *Multi) record(i int, errs []error) {
+	var failed error
+	for _, err := range errs {
+		if err != nil {
+			failed = err
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := &m.health[i]
+	if failed != nil {
+		if h.Healthy {
+			slog.Warn("sink unhealthy", "sink", h.Name, "error", failed)
+		}
+		h.Healthy = false
+		h.LastError = failed.Error()
+		h.Failures++
+		return
+	}
+	if len(errs) == 0 {
+		return
+	}
+	if !h.Healthy {
+		slog.Info("sink recovered", "sink", h.Name, "failures", h.Failures)
+	}
+	h.Healthy = true
+	h.LastSuccess = time.Now()
+	h.Failures = 0
+}
+
+// Health returns a snapshot of every sink's health, in configuration order,
+// followed by the health reported by nested sinks.
+func (m *Multi) Health() []Health {
+	m.mu.Lock()
+	out := append([]Health(nil), m.health...)
+	m.mu.Unlock()
+	for _, s := range m.sinks {
+		if hr, ok := s.(HealthReporter); ok {
+			out = append(out, hr.Health()...)
+		}
+	}
+	return out
+}
+
+// Ping checks every sink that supports it.
+func (m *Multi) Ping(ctx context.Context) error {
+	var errs []error
+	for _, s := range m.sinks {
+		if p, ok := s.(Pinger); ok {
+			if err := p.Ping(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Multi) Close() error {
+	var errs []error
+	for _, s := range m.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fill sets every entry of errs to err.
+func fill(errs []error, err error) []error {
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
@@ -0,0 +1,40 @@
+package sink_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"mysql_changelog_publisher/internal/sink"
+	"mysql_changelog_publisher/internal/sink/sinktest"
+)
+
+func TestMultiTargets(t *testing.T) {
+	file, r1, r2 := sinktest.New("file"), sinktest.New("redis[a]"), sinktest.New("redis[b]")
+	r2.SetDown(true)
+	m := sink.NewMulti(sink.All, file, sink.NewMulti(sink.All, r1, r2))
+
+	errs := m.Publish(context.Background(), []sink.Message{{ID: "1"}})
+	var te *sink.TargetsError
+	if !errors.As(errs[0], &te) {
+		t.Fatalf("error %v is not a *TargetsError", errs[0])
+	}
+	if want := []string{"redis[b]"}; !reflect.DeepEqual(te.Failed, want) {
+		t.Fatalf("failed targets %v, want %v", te.Failed, want)
+	}
+
+	// Retrying with the failed targets reaches only those.
+	r2.SetDown(false)
+	errs = m.Publish(context.Background(), []sink.Message{{ID: "1", Targets: te.Failed}, {ID: "2"}})
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+	for _, s := range []*sinktest.Fake{file, r1, r2} {
+		if want := []string{"1", "2"}; !reflect.DeepEqual(s.Delivered(), want) {
+			t.Errorf("%s got %v, want %v", s.Name(), s.Delivered(), want)
+		}
+	}
+}
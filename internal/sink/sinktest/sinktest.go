@@ -0,0 +1,59 @@
+// Package sinktest provides a fake sink for tests of code that publishes.
+package sinktest
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"mysql_changelog_publisher/internal/sink"
+)
+
+// ErrDown is what a Fake returns for every message while it is down.
+var ErrDown = errors.New("down")
+
+// Fake records the ids of the messages it delivers and fails while down.
+// It is safe for concurrent use.
+type Fake struct {
+	name string
+
+	mu   sync.Mutex
+	down bool
+	got  []string
+}
+
+func New(name string) *Fake {
+	return &Fake{name: name}
+}
+
+func (f *Fake) Name() string { return f.name }
+
+func (f *Fake) Publish(ctx context.Context, msgs []sink.Message) []error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	errs := make([]error, len(msgs))
+	for i, m := range msgs {
+		if f.down {
+			errs[i] = ErrDown
+			continue
+		}
+		f.got = append(f.got, m.ID)
+	}
+	return errs
+}
+
+func (f *Fake) Close() error { return nil }
+
+// SetDown makes later publishes fail (true) or succeed again (false).
+func (f *Fake) SetDown(down bool) {
+	f.mu.Lock()
+	f.down = down
+	f.mu.Unlock()
+}
+
+// Delivered returns the ids delivered so far, in order.
+func (f *Fake) Delivered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.got...)
+}
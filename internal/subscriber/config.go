@@ -1,13 +1,24 @@
 package subscriber
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
+	"time"
+
+	"mysql_changelog_publisher/internal/redisconn"
+
+	"github.com/redis/go-redis/v9"
 )
 
 const (
 	DefaultRedisAddr    = "127.0.0.1:6379"
 	DefaultRedisChannel = "binlog:all"
+	DefaultSigningAlg   = "hmac-sha256"
+	DefaultNATSURL      = "nats://127.0.0.1:4222"
+	DefaultNATSStream   = "BINLOG"
+	DefaultNATSSubject  = "binlog.>"
+	DefaultNATSConsumer = "binlog-subscriber"
 )
 
 type Config struct {
@@ -18,6 +29,27 @@ type Config struct {
 	RedisChannel string
 	PrettyPrint  bool
 
+	// Full connection settings (Sentinel, Cluster, TLS, ACL user); RedisAddr,
+	// RedisPass and RedisDB mirror the basic ones for logging.
+	Redis    redisconn.Options
+	redisErr error
+
+	// Where events come from: redis (pub/sub) or nats (JetStream)
+	Source        string
+	NATSURL       string
+	NATSCredsFile string
+	NATSStream    string
+	NATSConsumer  string
+	NATSSubject   string
+
+	// Keyring for encrypted payloads (see internal/envelope)
+	KeyringFile string
+
+	// Signature verification
+	RequireSignedEvents bool
+	SigningAlg          string
+	VerifyKeyFile       string
+
 	FilterDBs       []string
 	FilterTables    []string
 	FilterIDs       []string
@@ -28,6 +60,22 @@ type Config struct {
 	// Exclude filters (blacklist)
 	ExcludeDBs    []string
 	ExcludeTables []string
+
+	// Skip events whose cdc-origin tag matches (loop prevention)
+	IgnoreOrigins []string
+
+	// Report a stall when no heartbeat event arrives for this long; zero
+	// means three of the emitter's heartbeat intervals, once one was seen.
+	HeartbeatMaxAge time.Duration
+
+	// Optional; updated by Run for health checks
+	Status *Status
+
+	// Optional; per-message counts from Run
+	Metrics Metrics
+
+	// Optional; when set Run reads from it instead of connecting to Source
+	Input Source
 }
 
 type EnvLookup func(string) (string, bool)
@@ -56,6 +104,35 @@ func LoadConfigFromLookup(lookup EnvLookup) *Config {
 		// Exclude filters (blacklist)
 		ExcludeDBs:    parseCSV(get("EXCLUDE_DBS")),
 		ExcludeTables: parseCSV(get("EXCLUDE_TABLES")),
+		IgnoreOrigins: parseCSV(get("IGNORE_ORIGINS")),
+
+		// Payload encryption and signatures
+		KeyringFile:         get("EVENT_KEYRING_FILE"),
+		RequireSignedEvents: envBool(get("REQUIRE_SIGNED_EVENTS"), false),
+		SigningAlg:          envDefault(get("EVENT_SIGNING_ALG"), DefaultSigningAlg),
+		VerifyKeyFile:       get("EVENT_VERIFY_KEY_FILE"),
+
+		// Source selection
+		Source:        strings.ToLower(envDefault(get("SUBSCRIBER_SOURCE"), SourceRedis)),
+		NATSURL:       envDefault(get("NATS_URL"), DefaultNATSURL),
+		NATSCredsFile: get("NATS_CREDS"),
+		NATSStream:    envDefault(get("NATS_STREAM"), DefaultNATSStream),
+		NATSConsumer:  get("NATS_CONSUMER"),
+		NATSSubject:   envDefault(get("NATS_SUBJECT"), DefaultNATSSubject),
+	}
+
+	// Durable names may not contain '.', '*', '>' or whitespace.
+	if cfg.NATSConsumer == "" && strings.TrimSpace(cfg.Name) != "" {
+		cfg.NATSConsumer = strings.Map(func(r rune) rune {
+			switch r {
+			case '.', '*', '>', ' ', '\t':
+				return '-'
+			}
+			return r
+		}, strings.TrimSpace(cfg.Name))
+	}
+	if cfg.NATSConsumer == "" {
+		cfg.NATSConsumer = DefaultNATSConsumer
 	}
 
 	if cfg.RedisChannel == "" {
@@ -70,10 +147,29 @@ func LoadConfigFromLookup(lookup EnvLookup) *Config {
 			cfg.RedisDB = v
 		}
 	}
+	if v := strings.TrimSpace(get("HEARTBEAT_MAX_AGE")); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.HeartbeatMaxAge = d
+		}
+	}
+	cfg.Redis, cfg.redisErr = redisconn.FromEnv(get)
 
 	return cfg
 }
 
+// RedisClient connects to Redis as configured, reporting any invalid Redis
+// setting found while loading the config.
+func (c *Config) RedisClient() (redis.UniversalClient, error) {
+	if c.redisErr != nil {
+		return nil, c.redisErr
+	}
+	client, err := c.Redis.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return client, nil
+}
+
 func LoadConfigFromMap(env map[string]string) *Config {
 	return LoadConfigFromLookup(func(key string) (string, bool) {
 		v, ok := env[key]
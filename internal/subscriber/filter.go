@@ -13,6 +13,7 @@ type Filter struct {
 	changeAll       strset
 	excludeDBSet    strset
 	excludeTableSet strset
+	ignoreOriginSet strset
 }
 
 func NewFilter(cfg *Config) *Filter {
@@ -25,10 +26,15 @@ func NewFilter(cfg *Config) *Filter {
 		changeAll:       toSet(cfg.FilterChangeAll, false),
 		excludeDBSet:    toSet(cfg.ExcludeDBs, false),
 		excludeTableSet: toSet(cfg.ExcludeTables, false),
+		ignoreOriginSet: toSet(cfg.IgnoreOrigins, false),
 	}
 }
 
 func (f *Filter) Matches(ev *event.RowEvent) bool {
+	if ev.Op == event.OpHeartbeat {
+		return false
+	}
+
 	// Check exclude filters first (blacklist)
 	if f.excludeDBSet != nil && len(f.excludeDBSet) > 0 {
 		if _, excluded := f.excludeDBSet[ev.DB]; excluded {
@@ -41,6 +47,10 @@ func (f *Filter) Matches(ev *event.RowEvent) bool {
 		}
 	}
 
+	if isIgnoredOrigin(f.ignoreOriginSet, ev.Origin) {
+		return false
+	}
+
 	// Check include filters (whitelist)
 	if !inSet(f.dbSet, ev.DB) {
 		return false
@@ -36,6 +36,16 @@ func inSet(s strset, v string) bool {
 	return ok
 }
 
+// isIgnoredOrigin reports whether an event was written by one of the
+// origins a subscriber wants to skip. Untagged events are never ignored.
+func isIgnoredOrigin(s strset, origin string) bool {
+	if s == nil || origin == "" {
+		return false
+	}
+	_, ok := s[origin]
+	return ok
+}
+
 func rowKeyToString(v interface{}) string {
 	switch t := v.(type) {
 	case string:
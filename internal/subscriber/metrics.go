@@ -0,0 +1,24 @@
+package subscriber
+
+import "time"
+
+// Metrics receives per-message counts from Run. Set Config.Metrics to export
+// them, e.g. to Prometheus.
+type Metrics interface {
+	Received()
+	// DecodeError counts a message dropped before filtering; reason is
+	// "json", "undecryptable" or "unverified".
+	DecodeError(reason string)
+	Filtered()
+	Matched()
+	// Heartbeat records a heartbeat event that took lag to arrive.
+	Heartbeat(lag time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Received()               {}
+func (nopMetrics) DecodeError(string)      {}
+func (nopMetrics) Filtered()               {}
+func (nopMetrics) Matched()                {}
+func (nopMetrics) Heartbeat(time.Duration) {}
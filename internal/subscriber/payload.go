@@ -0,0 +1,93 @@
+package subscriber
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"mysql_changelog_publisher/internal/envelope"
+)
+
+var (
+	ErrUndecryptable = errors.New("undecryptable message")
+	ErrUnverified    = errors.New("unverified message")
+)
+
+// PayloadOpener unwraps raw channel messages before they are decoded as
+// events: it checks signatures, then decrypts sealed payloads. Unsigned or
+// plaintext payloads are passed through unless the config requires them,
+// so publishers can be migrated one at a time.
+type PayloadOpener struct {
+	keyring       *envelope.Keyring
+	verifier      *envelope.Verifier
+	requireSigned bool
+
+	undecryptable atomic.Int64
+	rejected      atomic.Int64
+}
+
+func NewPayloadOpener(cfg *Config) (*PayloadOpener, error) {
+	o := &PayloadOpener{requireSigned: cfg.RequireSignedEvents}
+	if cfg.KeyringFile != "" {
+		kr, err := envelope.LoadKeyring(cfg.KeyringFile)
+		if err != nil {
+			return nil, fmt.Errorf("load keyring: %w", err)
+		}
+		o.keyring = kr
+	}
+	if cfg.VerifyKeyFile != "" {
+		v, err := envelope.NewVerifier(cfg.SigningAlg, cfg.VerifyKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load verify key: %w", err)
+		}
+		o.verifier = v
+	} else if cfg.RequireSignedEvents {
+		return nil, fmt.Errorf("REQUIRE_SIGNED_EVENTS needs EVENT_VERIFY_KEY_FILE")
+	}
+	return o, nil
+}
+
+// Open returns the plaintext JSON for raw. Signature failures wrap
+// ErrUnverified and decryption failures wrap ErrUndecryptable; both are
+// counted.
+func (o *PayloadOpener) Open(raw string) (string, error) {
+	data := []byte(raw)
+
+	if o.verifier != nil {
+		payload, err := o.verifier.Verify(data)
+		switch {
+		case err == nil:
+			data = payload
+		case errors.Is(err, envelope.ErrUnsigned) && !o.requireSigned:
+		default:
+			o.rejected.Add(1)
+			return "", fmt.Errorf("%w: %v", ErrUnverified, err)
+		}
+	} else if msg, ok := envelope.ParseSigned(data); ok {
+		data = msg.Payload
+	}
+
+	if !envelope.IsSealed(data) {
+		return string(data), nil
+	}
+	if o.keyring == nil {
+		o.undecryptable.Add(1)
+		return "", fmt.Errorf("%w: encrypted payload but no EVENT_KEYRING_FILE configured", ErrUndecryptable)
+	}
+	pt, err := o.keyring.Open(data)
+	if err != nil {
+		o.undecryptable.Add(1)
+		return "", fmt.Errorf("%w: %v", ErrUndecryptable, err)
+	}
+	return string(pt), nil
+}
+
+// Undecryptable returns how many messages could not be decrypted so far.
+func (o *PayloadOpener) Undecryptable() int64 {
+	return o.undecryptable.Load()
+}
+
+// Rejected returns how many messages failed signature checks so far.
+func (o *PayloadOpener) Rejected() int64 {
+	return o.rejected.Load()
+}
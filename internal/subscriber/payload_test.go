@@ -0,0 +1,109 @@
+package subscriber
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mysql_changelog_publisher/internal/envelope"
+)
+
+const (
+	testKeyring = `{"active": "k1", "keys": {"k1": "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="}}`
+	testHMACKey = "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA="
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sealAndSign wraps payload the way the emitter does: seal, then sign.
+func sealAndSign(t *testing.T, keyringFile, keyFile, payload string) string {
+	t.Helper()
+	kr, err := envelope.LoadKeyring(keyringFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := envelope.NewSigner(envelope.AlgHMACSHA256, keyFile, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := kr.Seal([]byte(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := s.Sign(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func TestPayloadOpenerSealedAndSigned(t *testing.T) {
+	keyringFile := writeFile(t, "keyring.json", testKeyring)
+	keyFile := writeFile(t, "hmac.key", testHMACKey)
+	raw := sealAndSign(t, keyringFile, keyFile, `{"event_id":"crm.leads:create:1"}`)
+
+	o, err := NewPayloadOpener(&Config{
+		KeyringFile:         keyringFile,
+		SigningAlg:          envelope.AlgHMACSHA256,
+		VerifyKeyFile:       keyFile,
+		RequireSignedEvents: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := o.Open(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != `{"event_id":"crm.leads:create:1"}` {
+		t.Errorf("Open = %s", got)
+	}
+
+	// Required signatures reject plaintext; a forged one is rejected too.
+	if _, err := o.Open(`{"event_id":"crm.leads:create:1"}`); !errors.Is(err, ErrUnverified) {
+		t.Errorf("Open(unsigned) error = %v, want ErrUnverified", err)
+	}
+	forgedKey := writeFile(t, "forged.key", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32))))
+	if _, err := o.Open(sealAndSign(t, keyringFile, forgedKey, `{}`)); !errors.Is(err, ErrUnverified) {
+		t.Errorf("Open(forged) error = %v, want ErrUnverified", err)
+	}
+	if o.Rejected() != 2 {
+		t.Errorf("Rejected = %d, want 2", o.Rejected())
+	}
+}
+
+func TestPayloadOpenerWithoutKeyring(t *testing.T) {
+	keyringFile := writeFile(t, "keyring.json", testKeyring)
+	keyFile := writeFile(t, "hmac.key", testHMACKey)
+	raw := sealAndSign(t, keyringFile, keyFile, `{"n":1}`)
+
+	// No verify key: the signature is stripped unchecked, but the sealed
+	// payload can't be read.
+	o, err := NewPayloadOpener(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Open(raw); !errors.Is(err, ErrUndecryptable) {
+		t.Errorf("Open error = %v, want ErrUndecryptable", err)
+	}
+	if o.Undecryptable() != 1 {
+		t.Errorf("Undecryptable = %d, want 1", o.Undecryptable())
+	}
+	if got, err := o.Open(`{"n":1}`); err != nil || got != `{"n":1}` {
+		t.Errorf("Open(plaintext) = %s, %v", got, err)
+	}
+
+	if _, err := NewPayloadOpener(&Config{RequireSignedEvents: true}); err == nil {
+		t.Error("REQUIRE_SIGNED_EVENTS without a verify key was accepted")
+	}
+}
@@ -5,31 +5,38 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"strings"
-	"time"
 
 	"mysql_changelog_publisher/internal/event"
-
-	"github.com/redis/go-redis/v9"
+	"mysql_changelog_publisher/internal/logging"
 )
 
 func Run(ctx context.Context, cfg *Config) error {
+	// Matching events are the output: printed to stdout, with the
+	// subscriber's name as prefix. Logs go through slog.
 	logPrefix := ""
+	logger := slog.Default()
 	if strings.TrimSpace(cfg.Name) != "" {
 		logPrefix = "[" + cfg.Name + "] "
+		logger = logger.With(logging.KeySubscriber, cfg.Name)
+	}
+	opener, err := NewPayloadOpener(cfg)
+	if err != nil {
+		return err
 	}
-	log.Printf("%ssubscriber start | redis=%s db=%d channel=%s", logPrefix, cfg.RedisAddr, cfg.RedisDB, cfg.RedisChannel)
-
-	client := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisAddr,
-		Password: cfg.RedisPass,
-		DB:       cfg.RedisDB,
-	})
-	defer client.Close()
 
-	pubsub := client.Subscribe(ctx, cfg.RedisChannel)
-	defer pubsub.Close()
+	src, err := OpenSource(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	logger.Info("subscriber start", "source", src.String())
+	cfg.Status.Running(src)
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
 
 	// Build filter sets
 	dbSet := toSet(cfg.FilterDBs, false)
@@ -38,33 +45,40 @@ func Run(ctx context.Context, cfg *Config) error {
 	opSet := toSet(cfg.FilterOps, true)
 	changeAny := toSet(cfg.FilterChangeAny, false)
 	changeAll := toSet(cfg.FilterChangeAll, false)
+	ignoreOrigins := toSet(cfg.IgnoreOrigins, false)
+
+	matches := func(ev *event.RowEvent) bool {
+		return !isIgnoredOrigin(ignoreOrigins, ev.Origin) &&
+			inSet(dbSet, ev.DB) &&
+			inSet(tableSet, ev.Table) &&
+			inSet(idSet, rowKeyToString(ev.RowKey)) &&
+			inSet(opSet, strings.ToLower(ev.Op)) &&
+			hasAnyColumns(ev.Changes, changeAny) &&
+			hasAllColumns(ev.Changes, changeAll)
+	}
 
 	handle := func(raw string) error {
 		var ev event.RowEvent
 		dec := json.NewDecoder(strings.NewReader(raw))
 		dec.UseNumber()
 		if err := dec.Decode(&ev); err != nil {
+			metrics.DecodeError("json")
 			return fmt.Errorf("json decode: %w", err)
 		}
 
-		if !inSet(dbSet, ev.DB) {
-			return nil
-		}
-		if !inSet(tableSet, ev.Table) {
-			return nil
-		}
-		if !inSet(idSet, rowKeyToString(ev.RowKey)) {
+		if ev.Op == event.OpHeartbeat {
+			lag := cfg.Status.Heartbeat(ev.Heartbeat)
+			metrics.Heartbeat(lag)
+			logger.Debug("heartbeat received", "lag", lag)
 			return nil
 		}
-		if !inSet(opSet, strings.ToLower(ev.Op)) {
-			return nil
-		}
-		if !hasAnyColumns(ev.Changes, changeAny) {
-			return nil
-		}
-		if !hasAllColumns(ev.Changes, changeAll) {
+		if !matches(&ev) {
+			metrics.Filtered()
 			return nil
 		}
+		metrics.Matched()
+		logger.Debug("event matched", logging.KeyDB, ev.DB, logging.KeyTable, ev.Table,
+			logging.KeyOp, ev.Op, logging.KeyRowKey, rowKeyToString(ev.RowKey))
 
 		if cfg.PrettyPrint {
 			var obj map[string]interface{}
@@ -77,22 +91,40 @@ func Run(ctx context.Context, cfg *Config) error {
 		return nil
 	}
 
-	msgs := pubsub.Channel(redis.WithChannelHealthCheckInterval(10 * time.Second))
 	for {
-		select {
-		case <-ctx.Done():
-			if err := pubsub.Close(); err != nil && !errors.Is(err, context.Canceled) {
-				log.Printf("%spubsub close error: %v", logPrefix, err)
+		msg, err := src.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				return fmt.Errorf("receive: %w", err)
 			}
-			_ = client.Close()
-			return ctx.Err()
-		case msg := <-msgs:
-			if msg == nil || msg.Payload == "" {
-				continue
+			if err := src.Close(); err != nil && !errors.Is(err, context.Canceled) {
+				logger.Warn("source close", logging.Err(err))
 			}
-			if err := handle(msg.Payload); err != nil {
-				log.Printf("%shandler error: %v", logPrefix, err)
+			if opener.Undecryptable() > 0 || opener.Rejected() > 0 {
+				logger.Warn("dropped messages", "undecryptable", opener.Undecryptable(), "rejected", opener.Rejected())
 			}
+			return ctx.Err()
+		}
+		metrics.Received()
+		cfg.Status.Received()
+		raw, err := opener.Open(msg.Payload)
+		if err != nil {
+			if errors.Is(err, ErrUnverified) {
+				metrics.DecodeError("unverified")
+			} else {
+				metrics.DecodeError("undecryptable")
+			}
+			logger.Warn("dropping message", "undecryptable", opener.Undecryptable(), "rejected", opener.Rejected(), logging.Err(err))
+			_ = msg.Term()
+			continue
+		}
+		if err := handle(raw); err != nil {
+			logger.Error("handler error", logging.Err(err))
+			_ = msg.Term()
+			continue
+		}
+		if err := msg.Ack(); err != nil {
+			logger.Warn("ack", logging.Err(err))
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,82 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sliceSource hands out payloads, then cancels the run.
+type sliceSource struct {
+	payloads []string
+	cancel   context.CancelFunc
+}
+
+func (s *sliceSource) Next(ctx context.Context) (*Message, error) {
+	if len(s.payloads) == 0 {
+		s.cancel()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	m := &Message{Payload: s.payloads[0]}
+	s.payloads = s.payloads[1:]
+	return m, nil
+}
+
+func (s *sliceSource) String() string                 { return "slice" }
+func (s *sliceSource) Ping(ctx context.Context) error { return nil }
+func (s *sliceSource) Close() error                   { return nil }
+
+type countingMetrics struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (m *countingMetrics) add(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[name]++
+}
+
+func (m *countingMetrics) Received()                 { m.add("received") }
+func (m *countingMetrics) DecodeError(reason string) { m.add("decode_error:" + reason) }
+func (m *countingMetrics) Filtered()                 { m.add("filtered") }
+func (m *countingMetrics) Matched()                  { m.add("matched") }
+func (m *countingMetrics) Heartbeat(time.Duration)   { m.add("heartbeat") }
+
+func TestRunRecordsMetrics(t *testing.T) {
+	keyringFile := writeFile(t, "keyring.json", testKeyring)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m := &countingMetrics{counts: map[string]int{}}
+	cfg := &Config{
+		FilterTables: []string{"leads"},
+		Metrics:      m,
+		Input: &sliceSource{cancel: cancel, payloads: []string{
+			`{"op":"create","db":"crm","table":"leads","row_key":1}`,
+			`{"op":"create","db":"crm","table":"notes","row_key":1}`,
+			`{"op":"heartbeat","heartbeat":{"written_at":"2025-03-01T10:00:00Z"}}`,
+			`not json`,
+			sealAndSign(t, keyringFile, writeFile(t, "hmac.key", testHMACKey), `{}`),
+		}},
+	}
+	if err := Run(ctx, cfg); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v", err)
+	}
+
+	want := map[string]int{
+		"received":                   5,
+		"matched":                    1,
+		"filtered":                   1,
+		"heartbeat":                  1,
+		"decode_error:json":          1,
+		"decode_error:undecryptable": 1,
+	}
+	for name, n := range want {
+		if m.counts[name] != n {
+			t.Errorf("%s = %d, want %d (all: %v)", name, m.counts[name], n, m.counts)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	SourceRedis = "redis"
+	SourceNATS  = "nats"
+)
+
+var (
+	// natsAckWait is how long JetStream waits for an ack before redelivering.
+	natsAckWait = 30 * time.Second
+	// natsRedeliveryDelay spaces out redeliveries of messages a subscriber
+	// failed to handle.
+	natsRedeliveryDelay = 5 * time.Second
+)
+
+// ErrSourceClosed is returned by Next once the source has been closed.
+var ErrSourceClosed = errors.New("source closed")
+
+// Message is one raw payload received from a Source. Call Ack once it has
+// been handled, Nak when handling failed and should be retried, or Term when
+// it can never be handled (bad payload) so it is not redelivered. All are
+// no-ops for sources without delivery guarantees.
+type Message struct {
+	Payload string
+	// Seq is the message's position in its stream, the same for every
+	// redelivery; 0 when the source has none.
+	Seq uint64
+
+	ack        func() error
+	nak        func() error
+	term       func() error
+	inProgress func() error
+}
+
+func (m *Message) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+	return m.ack()
+}
+
+func (m *Message) Nak() error {
+	if m.nak == nil {
+		return nil
+	}
+	return m.nak()
+}
+
+func (m *Message) Term() error {
+	if m.term == nil {
+		return nil
+	}
+	return m.term()
+}
+
+// InProgress tells the source m is still being handled, restarting its ack
+// wait.
+func (m *Message) InProgress() error {
+	if m.inProgress == nil {
+		return nil
+	}
+	return m.inProgress()
+}
+
+// Hold keeps m from being redelivered while it waits longer than the ack
+// wait, e.g. for a debounced API call, by marking it in progress every third
+// of the ack wait until release is called. release may be called more than
+// once.
+func (m *Message) Hold() (release func()) {
+	if m.inProgress == nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	every := natsAckWait / 3
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				_ = m.inProgress()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// Source delivers raw event payloads to a subscriber.
+type Source interface {
+	// Next blocks until a message arrives, ctx is done or the source is
+	// closed.
+	Next(ctx context.Context) (*Message, error)
+	// String describes the source for logs.
+	String() string
+	// Ping checks the connection to the broker.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// OpenSource connects to the source selected by cfg.Source, or returns
+// cfg.Input when set.
+func OpenSource(ctx context.Context, cfg *Config) (Source, error) {
+	if cfg.Input != nil {
+		return cfg.Input, nil
+	}
+	switch strings.ToLower(cfg.Source) {
+	case "", SourceRedis:
+		return openRedisSource(ctx, cfg)
+	case SourceNATS:
+		return openNATSSource(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown SUBSCRIBER_SOURCE %q", cfg.Source)
+	}
+}
+
+type redisSource struct {
+	desc   string
+	client redis.UniversalClient
+	pubsub *redis.PubSub
+	msgs   <-chan *redis.Message
+}
+
+func openRedisSource(ctx context.Context, cfg *Config) (*redisSource, error) {
+	client, err := cfg.RedisClient()
+	if err != nil {
+		return nil, err
+	}
+	pubsub := client.Subscribe(ctx, cfg.RedisChannel)
+	return &redisSource{
+		desc:   fmt.Sprintf("redis=%s channel=%s", cfg.Redis, cfg.RedisChannel),
+		client: client,
+		pubsub: pubsub,
+		msgs:   pubsub.Channel(redis.WithChannelHealthCheckInterval(10 * time.Second)),
+	}, nil
+}
+
+func (s *redisSource) Next(ctx context.Context) (*Message, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case msg, ok := <-s.msgs:
+			if !ok {
+				return nil, ErrSourceClosed
+			}
+			if msg == nil || msg.Payload == "" {
+				continue
+			}
+			return &Message{Payload: msg.Payload}, nil
+		}
+	}
+}
+
+func (s *redisSource) String() string { return s.desc }
+
+func (s *redisSource) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *redisSource) Close() error {
+	err := s.pubsub.Close()
+	if cerr := s.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// natsSource consumes from a JetStream durable consumer with explicit acks,
+// so messages not acked before a restart are redelivered.
+type natsSource struct {
+	desc string
+	nc   *nats.Conn
+	iter jetstream.MessagesContext
+}
+
+func openNATSSource(ctx context.Context, cfg *Config) (*natsSource, error) {
+	opts := []nats.Option{nats.Name(cfg.NATSConsumer), nats.MaxReconnects(-1)}
+	if cfg.NATSCredsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.NATSCredsFile))
+	}
+	nc, err := nats.Connect(cfg.NATSURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("nats connect %s: %w", cfg.NATSURL, err)
+	}
+	s, err := newNATSSource(ctx, nc, cfg.NATSStream, cfg.NATSConsumer, cfg.NATSSubject)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	s.desc = fmt.Sprintf("nats=%s %s", cfg.NATSURL, s.desc)
+	return s, nil
+}
+
+// NewNATSSource consumes stream through the durable consumer, creating or
+// updating it to filter on subject. The source takes ownership of nc, which
+// may point at an embedded server.
+func NewNATSSource(ctx context.Context, nc *nats.Conn, stream, consumer, subject string) (Source, error) {
+	return newNATSSource(ctx, nc, stream, consumer, subject)
+}
+
+func newNATSSource(ctx context.Context, nc *nats.Conn, stream, consumer, subject string) (*natsSource, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, fmt.Errorf("jetstream: %w", err)
+	}
+	cons, err := js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:       consumer,
+		FilterSubject: subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       natsAckWait,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("consumer %s on stream %s: %w", consumer, stream, err)
+	}
+	iter, err := cons.Messages()
+	if err != nil {
+		return nil, fmt.Errorf("consume %s: %w", consumer, err)
+	}
+	return &natsSource{
+		desc: fmt.Sprintf("stream=%s consumer=%s subject=%s", stream, consumer, subject),
+		nc:   nc,
+		iter: iter,
+	}, nil
+}
+
+func (s *natsSource) Next(ctx context.Context) (*Message, error) {
+	// The iterator does not take a context; stop it to unblock Next.
+	stop := context.AfterFunc(ctx, s.iter.Stop)
+	defer stop()
+
+	msg, err := s.iter.Next()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			return nil, ErrSourceClosed
+		}
+		return nil, err
+	}
+	m := &Message{
+		Payload:    string(msg.Data()),
+		ack:        msg.Ack,
+		nak:        func() error { return msg.NakWithDelay(natsRedeliveryDelay) },
+		term:       msg.Term,
+		inProgress: msg.InProgress,
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		m.Seq = meta.Sequence.Stream
+	}
+	return m, nil
+}
+
+func (s *natsSource) String() string { return s.desc }
+
+func (s *natsSource) Ping(ctx context.Context) error {
+	if st := s.nc.Status(); st != nats.CONNECTED {
+		return fmt.Errorf("nats connection %s", st)
+	}
+	return nil
+}
+
+func (s *natsSource) Close() error {
+	s.iter.Stop()
+	return s.nc.Drain()
+}
+
+// Feed is a Source fed from a channel, for handing recorded payloads to a
+// subscriber in process. Next returns ErrSourceClosed once C is closed and
+// drained.
+type Feed struct {
+	Name string
+	C    <-chan string
+}
+
+func (f *Feed) Next(ctx context.Context) (*Message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case payload, ok := <-f.C:
+		if !ok {
+			return nil, ErrSourceClosed
+		}
+		return &Message{Payload: payload}, nil
+	}
+}
+
+func (f *Feed) String() string { return "feed=" + f.Name }
+
+func (f *Feed) Ping(ctx context.Context) error { return nil }
+
+func (f *Feed) Close() error { return nil }
@@ -0,0 +1,208 @@
+package subscriber
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func runJetStream(t *testing.T) *server.Server {
+	t.Helper()
+	opts := test.DefaultTestOptions
+	opts.Port = -1
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+	s := test.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func connect(t *testing.T, s *server.Server) *nats.Conn {
+	t.Helper()
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func setNATSTimings(t *testing.T, ackWait, redelivery time.Duration) {
+	oldWait, oldDelay := natsAckWait, natsRedeliveryDelay
+	natsAckWait, natsRedeliveryDelay = ackWait, redelivery
+	t.Cleanup(func() { natsAckWait, natsRedeliveryDelay = oldWait, oldDelay })
+}
+
+// publishEvents creates the BINLOG stream and publishes payloads to it.
+func publishEvents(t *testing.T, ctx context.Context, s *server.Server, payloads ...string) {
+	t.Helper()
+	js, err := jetstream.New(connect(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := js.CreateStream(ctx, jetstream.StreamConfig{Name: "BINLOG", Subjects: []string{"binlog.>"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range payloads {
+		if _, err := js.Publish(ctx, "binlog.crm.leads.create", []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func next(t *testing.T, src Source, within time.Duration) (*Message, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), within)
+	defer cancel()
+	return src.Next(ctx)
+}
+
+func TestNATSSourceRedeliversUnacked(t *testing.T) {
+	setNATSTimings(t, 500*time.Millisecond, 100*time.Millisecond)
+	s := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	publishEvents(t, ctx, s, "one", "two")
+
+	src, err := NewNATSSource(ctx, connect(t, s), "BINLOG", "sub", "binlog.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, err := next(t, src, 5*time.Second)
+	if err != nil || m1.Payload != "one" {
+		t.Fatalf("first message = %v, %v", m1, err)
+	}
+	if err := m1.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := next(t, src, 5*time.Second)
+	if err != nil || m2.Payload != "two" {
+		t.Fatalf("second message = %v, %v", m2, err)
+	}
+	// Stop without acking "two": the durable consumer hands it out again.
+	src.Close()
+
+	src, err = NewNATSSource(ctx, connect(t, s), "BINLOG", "sub", "binlog.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	m, err := next(t, src, 5*time.Second)
+	if err != nil || m.Payload != "two" {
+		t.Fatalf("after restart got %v, %v; want the unacked message", m, err)
+	}
+	if err := m.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := next(t, src, 2*natsAckWait); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("acked message delivered again: %v, %v", m, err)
+	}
+}
+
+func TestNATSSourceNakAndTerm(t *testing.T) {
+	setNATSTimings(t, 5*time.Second, 100*time.Millisecond)
+	s := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	publishEvents(t, ctx, s, "failing", "bad")
+
+	src, err := NewNATSSource(ctx, connect(t, s), "BINLOG", "sub", "binlog.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	m, err := next(t, src, 5*time.Second)
+	if err != nil || m.Payload != "failing" {
+		t.Fatalf("got %v, %v", m, err)
+	}
+	if err := m.Nak(); err != nil {
+		t.Fatal(err)
+	}
+	m, err = next(t, src, 5*time.Second)
+	if err != nil || m.Payload != "bad" {
+		t.Fatalf("got %v, %v", m, err)
+	}
+	if err := m.Term(); err != nil {
+		t.Fatal(err)
+	}
+	// Redelivered well before the ack wait; the terminated one never is.
+	m, err = next(t, src, 2*time.Second)
+	if err != nil || m.Payload != "failing" {
+		t.Fatalf("after nak got %v, %v; want redelivery", m, err)
+	}
+	if err := m.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := next(t, src, time.Second); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unexpected delivery: %v, %v", m, err)
+	}
+}
+
+func TestNATSSourceHoldPastAckWait(t *testing.T) {
+	setNATSTimings(t, 600*time.Millisecond, 100*time.Millisecond)
+	s := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	publishEvents(t, ctx, s, "debounced")
+
+	src, err := NewNATSSource(ctx, connect(t, s), "BINLOG", "sub", "binlog.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	m, err := next(t, src, 5*time.Second)
+	if err != nil || m.Payload != "debounced" {
+		t.Fatalf("got %v, %v", m, err)
+	}
+	if m.Seq == 0 {
+		t.Error("message has no stream sequence")
+	}
+
+	// A debounce several times the ack wait: held, it isn't redelivered
+	// before the ack.
+	release := m.Hold()
+	time.Sleep(4 * natsAckWait)
+	release()
+	if err := m.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := next(t, src, 2*natsAckWait); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("held message delivered again: %v, %v", m, err)
+	}
+}
+
+func TestNATSSourceRedeliveryKeepsSeq(t *testing.T) {
+	setNATSTimings(t, 300*time.Millisecond, 100*time.Millisecond)
+	s := runJetStream(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	publishEvents(t, ctx, s, "one")
+
+	src, err := NewNATSSource(ctx, connect(t, s), "BINLOG", "sub", "binlog.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	first, err := next(t, src, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not held: the ack wait expires and it comes back with the same Seq.
+	again, err := next(t, src, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Seq != first.Seq {
+		t.Errorf("redelivery has seq %d, first delivery %d", again.Seq, first.Seq)
+	}
+	if err := again.Ack(); err != nil {
+		t.Fatal(err)
+	}
+}
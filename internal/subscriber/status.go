@@ -0,0 +1,157 @@
+package subscriber
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mysql_changelog_publisher/internal/event"
+)
+
+// Subscriber states reported by Status.
+const (
+	StateStarting = "starting"
+	StateRunning  = "running"
+	StateStopped  = "stopped"
+	StateFailed   = "failed"
+)
+
+// Status tracks one running subscriber for health checks. Set Config.Status
+// to have Run keep it up to date.
+type Status struct {
+	Name string
+	// HeartbeatMaxAge is Config.HeartbeatMaxAge.
+	HeartbeatMaxAge time.Duration
+
+	mu      sync.Mutex
+	state   string
+	err     string
+	src     Source
+	started time.Time
+
+	lastMessage atomic.Int64 // unix nanos
+
+	lastHeartbeat     time.Time
+	heartbeatLag      time.Duration
+	heartbeatInterval time.Duration
+}
+
+func NewStatus(name string) *Status {
+	return &Status{Name: name, state: StateStarting, started: time.Now()}
+}
+
+// Running records that src is open and being consumed.
+func (s *Status) Running(src Source) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state, s.src, s.err = StateRunning, src, ""
+}
+
+// Received records that a message arrived.
+func (s *Status) Received() {
+	if s == nil {
+		return
+	}
+	s.lastMessage.Store(time.Now().UnixNano())
+}
+
+// Heartbeat records a heartbeat event and returns the lag from the emitter
+// writing the heartbeat row to its arrival here.
+func (s *Status) Heartbeat(hb *event.Heartbeat) time.Duration {
+	if s == nil || hb == nil {
+		return 0
+	}
+	now := time.Now()
+	var lag time.Duration
+	if written, err := time.Parse(time.RFC3339Nano, hb.WrittenAt); err == nil {
+		lag = max(0, now.Sub(written))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHeartbeat = now
+	s.heartbeatLag = lag
+	s.heartbeatInterval = time.Duration(hb.IntervalSeconds * float64(time.Second))
+	return lag
+}
+
+// stalledLocked reports whether heartbeats stopped arriving: none within
+// HeartbeatMaxAge (three emitter intervals when unset, which needs one
+// heartbeat first to learn the interval).
+func (s *Status) stalledLocked(now time.Time) bool {
+	maxAge := s.HeartbeatMaxAge
+	if maxAge <= 0 {
+		maxAge = 3 * s.heartbeatInterval
+	}
+	if maxAge <= 0 || s.state != StateRunning {
+		return false
+	}
+	last := s.lastHeartbeat
+	if last.IsZero() {
+		last = s.started
+	}
+	return now.Sub(last) > maxAge
+}
+
+// Stopped records that the subscriber exited; a nil or context error means
+// it was shut down rather than failed.
+func (s *Status) Stopped(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src = nil
+	if err == nil || err == context.Canceled {
+		s.state = StateStopped
+		return
+	}
+	s.state, s.err = StateFailed, err.Error()
+}
+
+// StatusReport is a point-in-time view of a subscriber.
+type StatusReport struct {
+	Name                  string   `json:"name"`
+	State                 string   `json:"state"`
+	Error                 string   `json:"error,omitempty"`
+	Source                string   `json:"source,omitempty"`
+	SourceError           string   `json:"source_error,omitempty"`
+	LastMessageAgeSeconds *float64 `json:"last_message_age_seconds,omitempty"`
+
+	LastHeartbeatAgeSeconds *float64 `json:"last_heartbeat_age_seconds,omitempty"`
+	HeartbeatLagSeconds     *float64 `json:"heartbeat_lag_seconds,omitempty"`
+	Stalled                 bool     `json:"stalled,omitempty"`
+}
+
+// Report describes the subscriber, pinging its source when running. Ready
+// means running with a reachable source and, when heartbeats are expected,
+// not stalled.
+func (s *Status) Report(ctx context.Context) (StatusReport, bool) {
+	now := time.Now()
+	s.mu.Lock()
+	r := StatusReport{Name: s.Name, State: s.state, Error: s.err}
+	src := s.src
+	if !s.lastHeartbeat.IsZero() {
+		age, lag := now.Sub(s.lastHeartbeat).Seconds(), s.heartbeatLag.Seconds()
+		r.LastHeartbeatAgeSeconds, r.HeartbeatLagSeconds = &age, &lag
+	}
+	r.Stalled = s.stalledLocked(now)
+	s.mu.Unlock()
+
+	if n := s.lastMessage.Load(); n > 0 {
+		age := time.Since(time.Unix(0, n)).Seconds()
+		r.LastMessageAgeSeconds = &age
+	}
+	if src == nil {
+		return r, false
+	}
+	r.Source = src.String()
+	if err := src.Ping(ctx); err != nil {
+		r.SourceError = err.Error()
+		return r, false
+	}
+	return r, r.State == StateRunning && !r.Stalled
+}